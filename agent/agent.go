@@ -10,11 +10,18 @@ var mA *MetaAgent
 
 type MetaAgent struct {
 	db *gorm.DB
+	// 只读从库
+	replicas replicaSet
 
 	// Entity代理相关
 	// todo 换成sync.Map
 	pool     map[string]NewFunc
 	listPool map[string]NewFunc
+
+	// 允许的relation类型及其基数
+	relationTypes map[relationTypeKey]Cardinality
+	// schema的删除策略
+	deletePolicies map[string]DeletePolicy
 }
 
 func NewMetaAgent(db *gorm.DB) *MetaAgent {
@@ -26,6 +33,7 @@ func NewMetaAgent(db *gorm.DB) *MetaAgent {
 	return ma
 }
 
+// GetDB 获取写连接，ctx中有事务连接则使用事务连接，否则使用主库
 func (ma *MetaAgent) GetDB(ctx context.Context) *gorm.DB {
 	// 获取db连接
 	db, err := ma.getTxConnFromContext(ctx)
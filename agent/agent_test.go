@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/lucky-loki/orm"
+)
+
+type testUser struct {
+	Entity
+
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func (u *testUser) SchemaName() string {
+	return "test_user"
+}
+
+func (u *testUser) NewFunc() interface{} {
+	return &testUser{}
+}
+
+func (u *testUser) NewListFunc() interface{} {
+	var list []*testUser
+	return &list
+}
+
+func (u *testUser) GetID() int64 {
+	return u.ID
+}
+
+func (u *testUser) SetID(id int64) {
+	u.ID = id
+}
+
+// newTestAgent 基于sqlite内存库创建MetaAgent，每个测试独享一个库，
+// 默认注册EntityRelation与testUser
+func newTestAgent(t *testing.T, schemas ...Schema) *MetaAgent {
+	c := &orm.Config{Database: t.Name()}
+	db, err := c.OpenSqlite()
+	if err != nil {
+		t.Fatalf("open sqlite failed: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ma := NewMetaAgent(db)
+	schemas = append([]Schema{new(EntityRelation), new(testUser)}, schemas...)
+	for _, s := range schemas {
+		ma.RegisterSchema(s)
+		if err = db.AutoMigrate(s).Error; err != nil {
+			t.Fatalf("migrate %s failed: %s", s.SchemaName(), err)
+		}
+	}
+	return ma
+}
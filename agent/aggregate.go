@@ -0,0 +1,220 @@
+package agent
+
+// 聚合查询，count/sum/avg/min/max与group by，列名均经过schema校验
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"regexp"
+	"strings"
+)
+
+// 聚合函数
+const (
+	AggFuncCount = "count"
+	AggFuncSum   = "sum"
+	AggFuncAvg   = "avg"
+	AggFuncMin   = "min"
+	AggFuncMax   = "max"
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Aggregate 聚合项，通过AggCount、AggSum等函数构造
+type Aggregate struct {
+	Func string
+	// 聚合列，只有count可以为空，表示count(*)
+	Column string
+	// 结果列名，为空时使用 {func}_{column}，count(*)为count
+	Alias string
+}
+
+func AggCount(column string) Aggregate {
+	return Aggregate{Func: AggFuncCount, Column: column}
+}
+
+func AggSum(column string) Aggregate {
+	return Aggregate{Func: AggFuncSum, Column: column}
+}
+
+func AggAvg(column string) Aggregate {
+	return Aggregate{Func: AggFuncAvg, Column: column}
+}
+
+func AggMin(column string) Aggregate {
+	return Aggregate{Func: AggFuncMin, Column: column}
+}
+
+func AggMax(column string) Aggregate {
+	return Aggregate{Func: AggFuncMax, Column: column}
+}
+
+// As 指定结果列名
+func (a Aggregate) As(alias string) Aggregate {
+	a.Alias = alias
+	return a
+}
+
+// AggregateQuery 聚合查询，通过MetaAgent.NewAggregateQuery创建
+//	q := ma.NewAggregateQuery(ma.NewQuery("order").Where(Eq("status", "PAID"))).
+//		GroupBy("user_id").
+//		Select(AggCount(""), AggSum("amount").As("total"))
+//	Query的排序列可以是分组列或聚合结果列名，Query的投影被忽略
+type AggregateQuery struct {
+	query   *Query
+	groupBy []string
+	aggs    []Aggregate
+}
+
+// NewAggregateQuery 基于查询构造器的条件、排序、分页创建聚合查询
+func (ma *MetaAgent) NewAggregateQuery(q *Query) *AggregateQuery {
+	return &AggregateQuery{query: q}
+}
+
+// GroupBy 添加分组列，分组列会出现在结果中
+func (a *AggregateQuery) GroupBy(columns ...string) *AggregateQuery {
+	a.groupBy = append(a.groupBy, columns...)
+	return a
+}
+
+// Select 添加聚合项
+func (a *AggregateQuery) Select(aggs ...Aggregate) *AggregateQuery {
+	a.aggs = append(a.aggs, aggs...)
+	return a
+}
+
+// build 编译select、group by、order by子句
+func (a *AggregateQuery) build(db *gorm.DB) (*gorm.DB, error) {
+	if len(a.aggs) == 0 {
+		return nil, errors.New("aggregate can not be empty")
+	}
+	q := a.query
+	db, err := q.apply(db, false)
+	if err != nil {
+		return nil, err
+	}
+	scope := db.NewScope(q.model)
+
+	var selects, groups []string
+	for _, name := range a.groupBy {
+		column, err := quotedColumn(scope, name)
+		if err != nil {
+			return nil, err
+		}
+		selects = append(selects, column)
+		groups = append(groups, column)
+	}
+	aliases := make(map[string]bool, len(a.aggs))
+	for _, agg := range a.aggs {
+		expr := "*"
+		if agg.Column != "" {
+			if expr, err = quotedColumn(scope, agg.Column); err != nil {
+				return nil, err
+			}
+		} else if agg.Func != AggFuncCount {
+			return nil, fmt.Errorf("%s requires a column", agg.Func)
+		}
+		switch agg.Func {
+		case AggFuncCount, AggFuncSum, AggFuncAvg, AggFuncMin, AggFuncMax:
+		default:
+			return nil, errors.New("unknown aggregate function: " + agg.Func)
+		}
+		alias := agg.Alias
+		if alias == "" {
+			alias = agg.Func
+			if agg.Column != "" {
+				column, _ := columnName(scope, agg.Column)
+				alias += "_" + column
+			}
+		}
+		if !aliasPattern.MatchString(alias) {
+			return nil, &IdentifierError{Schema: scope.TableName(), Column: alias}
+		}
+		aliases[alias] = true
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(agg.Func), expr, scope.Quote(alias)))
+	}
+	db = db.Select(strings.Join(selects, ", "))
+	if len(groups) > 0 {
+		db = db.Group(strings.Join(groups, ", "))
+	}
+
+	for _, o := range q.orders {
+		var column string
+		if aliases[o.column] {
+			column = scope.Quote(o.column)
+		} else if column, err = quotedColumn(scope, o.column); err != nil {
+			return nil, err
+		}
+		if o.desc {
+			column += " desc"
+		}
+		db = db.Order(column)
+	}
+	if q.limit > 0 {
+		db = db.Limit(q.limit)
+	}
+	if q.offset > 0 {
+		db = db.Offset(q.offset)
+	}
+	return db, nil
+}
+
+// AggregateEntity 执行聚合查询，每行结果为 列名/结果列名 => 值
+//	sql like:
+//		select {group_column1}, ..., {func}({column}) as {alias}, ... from {schema_name}
+//		where {conds...}
+//		group by {group_column1}, ...
+//		order by {column|alias} [desc], ...
+//		limit {limit} offset {offset}
+func (ma *MetaAgent) AggregateEntity(ctx context.Context, a *AggregateQuery) ([]map[string]interface{}, error) {
+	db, err := a.build(ma.GetReadDB(ctx))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// mysql驱动将文本、decimal返回为[]byte
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// ScanAggregate 执行聚合查询并将结果写入resultListPtr，其元素字段按列名与结果列名匹配
+//	var stats []struct {
+//		UserID int64
+//		Total  float64
+//	}
+//	err := ma.ScanAggregate(ctx, a, &stats)
+func (ma *MetaAgent) ScanAggregate(ctx context.Context, a *AggregateQuery, resultListPtr interface{}) error {
+	db, err := a.build(ma.GetReadDB(ctx))
+	if err != nil {
+		return err
+	}
+	return db.Scan(resultListPtr).Error
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMetaAgent_AggregateEntity(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	// a: 1 2 3, b: 4 5
+	for i := 1; i <= 5; i++ {
+		name := "a"
+		if i > 3 {
+			name = "b"
+		}
+		if err := ma.CreateEntity(ctx, &testUser{Name: name, Age: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := ma.NewAggregateQuery(ma.NewQuery("test_user").Where(Gt("age", 1)).OrderBy("count", true)).
+		GroupBy("name").
+		Select(AggCount(""), AggSum("age").As("total"), AggMax("Age"))
+	var stats []struct {
+		Name   string
+		Count  int
+		Total  int
+		MaxAge int
+	}
+	if err := ma.ScanAggregate(ctx, a, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(stats) != "[{a 2 5 3} {b 2 9 5}]" && fmt.Sprint(stats) != "[{b 2 9 5} {a 2 5 3}]" {
+		t.Errorf("got %v", stats)
+	}
+
+	rows, err := ma.AggregateEntity(ctx, ma.NewAggregateQuery(ma.NewQuery("test_user")).Select(AggAvg("age")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || fmt.Sprint(rows[0]["avg_age"]) != "3" {
+		t.Errorf("got %v", rows)
+	}
+
+	for _, a := range []*AggregateQuery{
+		ma.NewAggregateQuery(ma.NewQuery("test_user")),
+		ma.NewAggregateQuery(ma.NewQuery("test_user")).Select(AggSum("")),
+		ma.NewAggregateQuery(ma.NewQuery("test_user")).Select(Aggregate{Func: "stddev", Column: "age"}),
+		ma.NewAggregateQuery(ma.NewQuery("test_user")).Select(AggCount("").As(`c" from x --`)),
+		ma.NewAggregateQuery(ma.NewQuery("test_user")).Select(AggCount("")).GroupBy("password"),
+		ma.NewAggregateQuery(ma.NewQuery("test_user").OrderBy("total", false)).Select(AggCount("")),
+	} {
+		if _, err = ma.AggregateEntity(ctx, a); err == nil {
+			t.Errorf("aggregate %+v should fail", a)
+		}
+	}
+}
+
+func TestGetEntityAggregate(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i%2), Age: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	get := func(query url.Values) (int, []map[string]interface{}) {
+		w := httptest.NewRecorder()
+		path := "/entity/test_user/aggregate?" + query.Encode()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp struct {
+			Data getEntityAggregateResp `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data.List
+	}
+
+	code, list := get(url.Values{
+		"agg":       {"count,sum(age)"},
+		"group_by":  {"name"},
+		"filter":    {"age >= 2"},
+		"sort":      {"-sum_age"},
+		"page_size": {"1"},
+	})
+	// u0: 2 4, u1: 3 5
+	if code != http.StatusOK || fmt.Sprint(list) != "[map[count:2 name:u1 sum_age:8]]" {
+		t.Errorf("got status %d, list %v", code, list)
+	}
+
+	for _, query := range []url.Values{
+		{},
+		{"agg": {"median(age)"}},
+		{"agg": {"sum"}},
+		{"agg": {"count"}, "group_by": {"password"}},
+		{"agg": {"count"}, "sort": {"-unknown"}},
+	} {
+		if code, _ = get(query); code != http.StatusBadRequest {
+			t.Errorf("%v got status %d", query, code)
+		}
+	}
+}
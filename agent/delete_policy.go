@@ -0,0 +1,107 @@
+package agent
+
+// 删除entity时对引用它的relation的处理策略
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DeletePolicy entity删除策略
+type DeletePolicy string
+
+const (
+	// 不处理relation
+	DeletePolicyNone DeletePolicy = ""
+	// 删除引用该entity的relation
+	DeletePolicyCascade DeletePolicy = "cascade"
+	// 存在引用该entity的relation时拒绝删除
+	DeletePolicyRestrict DeletePolicy = "restrict"
+	// 禁用引用该entity的relation
+	DeletePolicyDisable DeletePolicy = "disable"
+)
+
+// ErrDeleteRestricted 删除策略为DeletePolicyRestrict且entity仍被relation引用
+var ErrDeleteRestricted = errors.New("entity is referenced by relations")
+
+// SetDeletePolicy 设置schema的删除策略，
+// 由DeleteEntityByID、DeleteEntityByStringCondition、DeleteEntitiesByID、DeleteEntityByQuery
+// 在删除entity的同一事务中执行
+func (ma *MetaAgent) SetDeletePolicy(schemaName string, policy DeletePolicy) {
+	if _, exist := ma.pool[schemaName]; !exist {
+		panic("schema not register: " + schemaName)
+	}
+	switch policy {
+	case DeletePolicyNone, DeletePolicyCascade, DeletePolicyRestrict, DeletePolicyDisable:
+	default:
+		panic("unknown delete policy: " + policy)
+	}
+	if ma.deletePolicies == nil {
+		ma.deletePolicies = map[string]DeletePolicy{}
+	}
+	ma.deletePolicies[schemaName] = policy
+}
+
+// GetDeletePolicy 返回schema的删除策略，未设置时为DeletePolicyNone
+func (ma *MetaAgent) GetDeletePolicy(schemaName string) DeletePolicy {
+	return ma.deletePolicies[schemaName]
+}
+
+// deletePolicyOf 返回model所属schema的删除策略
+func (ma *MetaAgent) deletePolicyOf(mPtr interface{}) (string, DeletePolicy) {
+	s, ok := mPtr.(Schema)
+	if !ok {
+		return "", DeletePolicyNone
+	}
+	return s.SchemaName(), ma.deletePolicies[s.SchemaName()]
+}
+
+// withDeletePolicy 按model所属schema的删除策略处理relation后执行删除，两者在同一事务中
+//	ids返回将被删除的entity id，只在需要处理relation时调用
+func (ma *MetaAgent) withDeletePolicy(ctx context.Context, mPtr interface{},
+	ids func(ctx context.Context) ([]int64, error), del txHandler) error {
+	schemaName, policy := ma.deletePolicyOf(mPtr)
+	if policy == DeletePolicyNone {
+		return del(ctx)
+	}
+	return ma.WithTransaction(ctx, func(ctx context.Context) error {
+		entityIDs, err := ids(ctx)
+		if err != nil {
+			return err
+		}
+		if err = ma.applyDeletePolicy(ctx, schemaName, policy, entityIDs); err != nil {
+			return err
+		}
+		return del(ctx)
+	})
+}
+
+// applyDeletePolicy 处理引用entity的relation，entity作为source或target均视为引用
+//	sql like:
+//		delete from entity_relation | update entity_relation set status='DISABLE'
+//		where (source_schema_name={schema_name} and source_entity_id in ({ids}))
+//		or (target_schema_name={schema_name} and target_entity_id in ({ids}))
+func (ma *MetaAgent) applyDeletePolicy(ctx context.Context, schemaName string, policy DeletePolicy, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db := ma.GetDB(ctx).Model(&EntityRelation{}).Where(
+		"(source_schema_name=? and source_entity_id in (?)) or (target_schema_name=? and target_entity_id in (?))",
+		schemaName, ids, schemaName, ids)
+	switch policy {
+	case DeletePolicyCascade:
+		return db.Delete(&EntityRelation{}).Error
+	case DeletePolicyDisable:
+		return db.Update("status", EntityRelationStatusDISABLE).Error
+	case DeletePolicyRestrict:
+		var count int
+		if err := db.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s has %d relations", ErrDeleteRestricted, schemaName, count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetaAgent_DeletePolicy(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	// 创建u0，u1 -> u0，u0 -> u2，u1 -> u2
+	setup := func(t *testing.T) []*testUser {
+		if err := ma.DeleteEntityByStringCondition(ctx, &EntityRelation{}, "1=1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ma.DeleteEntityByStringCondition(ctx, &testUser{}, "1=1"); err != nil {
+			t.Fatal(err)
+		}
+		users := []*testUser{{Name: "u0"}, {Name: "u1"}, {Name: "u2"}}
+		for _, u := range users {
+			if err := ma.CreateEntity(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, pair := range [][2]int{{1, 0}, {0, 2}, {1, 2}} {
+			if err := ma.CreateRelation(ctx, &EntityRelation{
+				SourceSchemaName: "test_user", SourceEntityID: users[pair[0]].ID,
+				TargetSchemaName: "test_user", TargetEntityID: users[pair[1]].ID,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return users
+	}
+	relationStatus := func(t *testing.T) []string {
+		var relations []*EntityRelation
+		if err, _ := ma.QueryEntityListByStringCondition(ctx, &relations, 0, 0, "id", false); err != nil {
+			t.Fatal(err)
+		}
+		var status []string
+		for _, r := range relations {
+			status = append(status, fmt.Sprintf("%d->%d:%s", r.SourceEntityID, r.TargetEntityID, r.Status))
+		}
+		return status
+	}
+
+	t.Run("none", func(t *testing.T) {
+		users := setup(t)
+		if err := ma.DeleteEntityByID(ctx, users[0]); err != nil {
+			t.Fatal(err)
+		}
+		if got := relationStatus(t); len(got) != 3 {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		ma.SetDeletePolicy("test_user", DeletePolicyCascade)
+		users := setup(t)
+		if err := ma.DeleteEntityByID(ctx, users[0]); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("[%d->%d:ENABLE]", users[1].ID, users[2].ID)
+		if got := fmt.Sprint(relationStatus(t)); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+		if err := ma.DeleteEntityByStringCondition(ctx, &testUser{}, "name=?", "u2"); err != nil {
+			t.Fatal(err)
+		}
+		if got := relationStatus(t); len(got) != 0 {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		ma.SetDeletePolicy("test_user", DeletePolicyDisable)
+		users := setup(t)
+		list := []*testUser{users[2]}
+		if err := ma.DeleteEntitiesByID(ctx, &list, 0); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("[%d->%d:ENABLE %d->%d:DISABLE %d->%d:DISABLE]",
+			users[1].ID, users[0].ID, users[0].ID, users[2].ID, users[1].ID, users[2].ID)
+		if got := fmt.Sprint(relationStatus(t)); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("restrict", func(t *testing.T) {
+		ma.SetDeletePolicy("test_user", DeletePolicyRestrict)
+		users := setup(t)
+		if err := ma.DeleteEntityByID(ctx, users[0]); !errors.Is(err, ErrDeleteRestricted) {
+			t.Errorf("got %v, want %v", err, ErrDeleteRestricted)
+		}
+		if n := countUsers(t, ma, "u0"); n != 1 {
+			t.Errorf("restricted entity deleted")
+		}
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		ma.RegisterGinHandler(router)
+		w := httptest.NewRecorder()
+		path := fmt.Sprintf("/entity/test_user/by/id/%d", users[1].ID)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, path, nil))
+		if w.Code != http.StatusConflict {
+			t.Errorf("got status %d", w.Code)
+		}
+
+		// 没有relation时可以删除
+		u := &testUser{Name: "u3"}
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if err := ma.DeleteEntityByStringCondition(ctx, &testUser{}, "name=?", "u3"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		ma.SetDeletePolicy("test_user", DeletePolicyRestrict)
+		setup(t)
+		q := ma.NewQuery("test_user").Where(Eq("name", "u0"))
+		if err := ma.DeleteEntityByQuery(ctx, q); !errors.Is(err, ErrDeleteRestricted) {
+			t.Errorf("got %v, want %v", err, ErrDeleteRestricted)
+		}
+		if n := countUsers(t, ma, "u0"); n != 1 {
+			t.Errorf("restricted entity deleted")
+		}
+
+		ma.SetDeletePolicy("test_user", DeletePolicyCascade)
+		users := setup(t)
+		if err := ma.DeleteEntityByQuery(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("[%d->%d:ENABLE]", users[1].ID, users[2].ID)
+		if got := fmt.Sprint(relationStatus(t)); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+}
@@ -3,7 +3,9 @@ package agent
 import (
 	"context"
 	"errors"
+	"github.com/jinzhu/gorm"
 	"github.com/lucky-loki/orm/agent/utils"
+	"reflect"
 	"time"
 )
 
@@ -11,6 +13,22 @@ type Checker interface {
 	Check() error
 }
 
+// AgentChecker 需要查询数据库的检查，如检查关联的entity是否存在，ctx强制读主库
+type AgentChecker interface {
+	Check(ctx context.Context, ma *MetaAgent) error
+}
+
+// checkEntity 写入前执行Checker或AgentChecker的检查
+func (ma *MetaAgent) checkEntity(ctx context.Context, mPtr interface{}) error {
+	switch c := mPtr.(type) {
+	case Checker:
+		return c.Check()
+	case AgentChecker:
+		return c.Check(WithPrimary(ctx), ma)
+	}
+	return nil
+}
+
 type SoftDeleter interface {
 	SoftDelete()
 }
@@ -28,43 +46,72 @@ type Entity struct {
 //			({value1}, {value2}, ...)
 func (ma *MetaAgent) CreateEntity(ctx context.Context, mPtr interface{}) error {
 	var err error
-	if c, ok := mPtr.(Checker); ok {
-		if err = c.Check(); err != nil {
-			return err
-		}
+	if err = ma.checkEntity(ctx, mPtr); err != nil {
+		return err
 	}
 	if s, ok := mPtr.(Schema); ok {
 		s.SetID(0)
 	}
+	if v, ok := mPtr.(Versioner); ok {
+		v.SetVersion(0)
+	}
 	db := ma.GetDB(ctx)
 	return db.Create(mPtr).Error
 }
 
 // UpdateEntityByID 全量更新，如果某字段为空就意味着更新为空值
+//	如果model实现了Versioner，则只有version与数据库一致时才更新，并将version加1，
+//	否则返回ErrVersionConflict
 //	sql like:
 //		update {schema_name}
 //		set column1={value1}, column2={value2},...
 //		where id={id}
 func (ma *MetaAgent) UpdateEntityByID(ctx context.Context, mPtr interface{}) error {
 	db := ma.GetDB(ctx)
+	if v, ok := mPtr.(Versioner); ok {
+		return updateVersionedEntity(db, mPtr, v)
+	}
 	return db.Save(mPtr).Error
 }
 
+// UpdateEntitySingleColumnByStringCondition 条件更新一个指定列，schema必须已注册，column必须是schema的列
+//	sql like:
+//		update {schema_name}
+//		set {column}={value}
+//		where {where...}
 func (ma *MetaAgent) UpdateEntitySingleColumnByStringCondition(
 	ctx context.Context, schema, column string, data interface{}, query string, args ...interface{}) error {
-	db := ma.GetDB(ctx)
-	err := db.Table(schema).Where(query, args...).Update(column, data).Error
-	return err
+	return ma.UpdateEntityMultipleColumnByStringCondition(ctx, schema, map[string]interface{}{column: data}, query, args...)
 }
 
+// UpdateEntityMultipleColumnByStringCondition 条件更新多个指定列，schema必须已注册，columns的key必须是schema的列
+//	model实现了Versioner时version加1
+//	sql like:
+//		update {schema_name}
+//		set {column1}={value1}, {column2}={value2}, ...
+//		where {where...}
 func (ma *MetaAgent) UpdateEntityMultipleColumnByStringCondition(
 	ctx context.Context, schema string, columns map[string]interface{}, query string, args ...interface{}) error {
+	mPtr, err := ma.schemaModel(schema)
+	if err != nil {
+		return err
+	}
 	db := ma.GetDB(ctx)
-	err := db.Table(schema).Where(query, args...).Updates(columns).Error
+	scope := db.NewScope(mPtr)
+	updates := make(map[string]interface{}, len(columns))
+	for name, value := range columns {
+		column, err := columnName(scope, name)
+		if err != nil {
+			return err
+		}
+		updates[column] = value
+	}
+	bumpVersion(mPtr, updates)
+	err = db.Table(scope.TableName()).Where(query, args...).Updates(updates).Error
 	return err
 }
 
-// DeleteEntityByID 通过ID删除数据
+// DeleteEntityByID 通过ID删除数据，按schema的删除策略处理relation
 //	如果model实现了软删除则执行update sql，否则执行delete sql
 //  sql like:
 //		update {schema_name}
@@ -74,28 +121,50 @@ func (ma *MetaAgent) UpdateEntityMultipleColumnByStringCondition(
 //		delete from schema_name
 //		where id={id}
 func (ma *MetaAgent) DeleteEntityByID(ctx context.Context, mPtr interface{}) error {
-	if d, ok := mPtr.(SoftDeleter); ok {
-		d.SoftDelete()
-		return ma.UpdateEntityByID(ctx, mPtr)
+	ids := func(ctx context.Context) ([]int64, error) {
+		id, _ := ma.GetDB(ctx).NewScope(mPtr).PrimaryKeyValue().(int64)
+		return []int64{id}, nil
 	}
-	db := ma.GetDB(ctx)
-	return db.Unscoped().Delete(mPtr).Error
+	return ma.withDeletePolicy(ctx, mPtr, ids, func(ctx context.Context) error {
+		if d, ok := mPtr.(SoftDeleter); ok {
+			d.SoftDelete()
+			return ma.UpdateEntityByID(ctx, mPtr)
+		}
+		db := ma.GetDB(ctx)
+		return db.Unscoped().Delete(mPtr).Error
+	})
 }
 
-// DeleteEntityByStringCondition 条件删除
+// DeleteEntityByStringCondition 条件删除，按schema的删除策略处理relation
+//	sql like:
+//		delete from {schema_name}
+//		where {where...}
 func (ma *MetaAgent) DeleteEntityByStringCondition(ctx context.Context, mPtr interface{}, cond string, args ...interface{}) error {
-	db := ma.GetDB(ctx)
-	return db.Unscoped().Where(cond, args...).Delete(mPtr).Error
+	ids := func(ctx context.Context) ([]int64, error) {
+		var ids []int64
+		err := ma.GetDB(ctx).Unscoped().Model(mPtr).Where(cond, args...).Pluck("id", &ids).Error
+		return ids, err
+	}
+	return ma.withDeletePolicy(ctx, mPtr, ids, func(ctx context.Context) error {
+		db := ma.GetDB(ctx)
+		return db.Unscoped().Where(cond, args...).Delete(mPtr).Error
+	})
 }
 
-// UpdateEntitySingleColumnByID 通过ID更新一个指定列
+// UpdateEntitySingleColumnByID 通过ID更新一个指定列，model实现了Versioner时version加1
 //	sql like:
 //		update {schema_name}
 //		set {column}={value}
 //		where id={id}
 func (ma *MetaAgent) UpdateEntitySingleColumnByID(ctx context.Context, mPtr interface{}, column string, value interface{}) error {
 	db := ma.GetDB(ctx)
-	return db.Model(mPtr).Update(column, value).Error
+	column, err := columnName(db.NewScope(mPtr), column)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{column: value}
+	bumpVersion(mPtr, updates)
+	return db.Model(mPtr).Updates(updates).Error
 }
 
 // GetTempCache 获取一个临时的查询缓存，不保证查询结果与最新的数据库结果一致
@@ -114,7 +183,7 @@ func (ma *MetaAgent) GetTempCache(ctx context.Context, schemaName string, cond s
 
 // GetEntityByID 根据主键查询Entity
 func (ma *MetaAgent) QueryEntity(ctx context.Context, mPtr interface{}) error {
-	db := ma.GetDB(ctx)
+	db := ma.GetReadDB(ctx)
 	return db.Find(mPtr).Error
 }
 
@@ -123,7 +192,7 @@ func (ma *MetaAgent) QueryEntity(ctx context.Context, mPtr interface{}) error {
 //		select (column1, column2,...) from {schema_name}
 //		where {where...}
 func (ma *MetaAgent) QueryOneEntityByStringFilter(ctx context.Context, mPtr interface{}, cond string, args ...interface{}) error {
-	db := ma.GetDB(ctx)
+	db := ma.GetReadDB(ctx)
 	return db.Where(cond, args...).First(mPtr).Error
 }
 
@@ -132,82 +201,103 @@ func (ma *MetaAgent) QueryOneEntityByStringFilter(ctx context.Context, mPtr inte
 //		select (column1, column2,...) from {schema_name}
 //		where {where...}
 func (ma *MetaAgent) QueryOneEntityByStructFilter(ctx context.Context, mPtr interface{}, filter interface{}) error {
-	db := ma.GetDB(ctx)
+	db := ma.GetReadDB(ctx)
 	return db.Where(filter).First(mPtr).Error
 }
 
+type withoutTotalKey struct{}
+
+// WithoutTotal 返回的ctx用于QueryEntityListBy*Condition时不统计总数，total返回-1，
+// 适用于不需要总页数的无限滚动等场景
+func WithoutTotal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutTotalKey{}, true)
+}
+
+func isWithoutTotal(ctx context.Context) bool {
+	without, _ := ctx.Value(withoutTotalKey{}).(bool)
+	return without
+}
+
 // QueryEntityListByStringCondition 通过过滤条件进行分页查询，
-//	如果pageSize为0则变更成全量查询且忽视page值
+//	如果pageSize为0则变更成全量查询且忽视page值，order必须是model的列
+//	ctx通过WithoutTotal创建时不执行count，total返回-1
 //	sql like:
+//		select count(*) from {schema_name}
+//		where {where...}
+//
 //		select (column1, column2,...) from {schema_name}
 //		where {where...}
 //		[order by {column} [desc]]
 //		[offset {pageSize * (page - 1)} limit {pageSize}]
 func (ma *MetaAgent) QueryEntityListByStringCondition(ctx context.Context, modelListPtr interface{}, pageSize, page int, order string, desc bool, filter ...interface{}) (err error, total int) {
-	db := ma.GetDB(ctx)
+	db := ma.GetReadDB(ctx)
 	// 添加过滤条件
 	if len(filter) > 0 {
 		db = db.Where(filter[0], filter[1:]...)
 	}
-	// 检查数据库是否有数据
-	err = db.Find(modelListPtr).Count(&total).Error
-	if err != nil || total == 0 {
-		return
-	}
-	// 分页查询
-	if pageSize != 0 {
-		if page == 0 {
-			page = 1
-		}
-		offset := pageSize * (page - 1)
-
-		db = db.Limit(pageSize).Offset(offset)
-	}
-	//是否排序
-	if order != "" {
-		if desc {
-			order = order + " desc"
-		}
-		db = db.Order(order, true)
-	}
-	err = db.Find(modelListPtr).Error
-	return
+	return queryEntityPage(ctx, db, modelListPtr, pageSize, page, order, desc)
 }
 
 // QueryEntityListByStructCondition 通过struct过滤条件进行分页查询，
-//	如果pageSize为0则变更成全量查询且忽视page值
+//	如果pageSize为0则变更成全量查询且忽视page值，order必须是model的列
+//	ctx通过WithoutTotal创建时不执行count，total返回-1
 //	sql like:
+//		select count(*) from {schema_name}
+//		where {filter...}
+//
 //		select (column1, column2,...) from {schema_name}
 //		where {filter...}
 //		[order by {column} [desc]]
 //		[offset {pageSize * (page - 1)} limit {pageSize}]
 func (ma *MetaAgent) QueryEntityListByStructCondition(ctx context.Context, modelListPtr interface{}, pageSize, page int, order string, desc bool, filter interface{}) (err error, total int) {
-	db := ma.GetDB(ctx)
+	db := ma.GetReadDB(ctx)
 	// 添加过滤条件
 	if filter != nil {
 		db = db.Where(filter)
 	}
-	// 检查数据库是否有数据
-	err = db.Find(modelListPtr).Count(&total).Error
-	if err != nil || total == 0 {
+	return queryEntityPage(ctx, db, modelListPtr, pageSize, page, order, desc)
+}
+
+// queryEntityPage 在已添加过滤条件的db上统计总数并查询一页数据
+//	全量查询时总数即结果条数，不再单独count；总数为0或页码超出总数时不再查询数据
+func queryEntityPage(ctx context.Context, db *gorm.DB, modelListPtr interface{}, pageSize, page int, order string, desc bool) (err error, total int) {
+	// 校验排序列
+	order, err = orderClause(db.NewScope(newListElem(modelListPtr)), order, desc)
+	if err != nil {
 		return
 	}
-	// 分页查询
-	if pageSize != 0 {
-		if page == 0 {
-			page = 1
+	if order != "" {
+		db = db.Order(order, true)
+	}
+	list := reflect.ValueOf(modelListPtr).Elem()
+
+	// 全量查询
+	if pageSize == 0 {
+		if err = db.Find(modelListPtr).Error; err != nil {
+			return
+		}
+		total = list.Len()
+		if isWithoutTotal(ctx) {
+			total = -1
 		}
-		offset := pageSize * (page - 1)
+		return
+	}
 
-		db = db.Limit(pageSize).Offset(offset)
+	if page == 0 {
+		page = 1
 	}
-	//是否排序
-	if order != "" {
-		if desc {
-			order = order + " desc"
+	offset := pageSize * (page - 1)
+	total = -1
+	if !isWithoutTotal(ctx) {
+		// 单独count，不带排序
+		if err = db.Model(modelListPtr).Order("", true).Count(&total).Error; err != nil {
+			return
+		}
+		if total <= offset {
+			list.Set(reflect.MakeSlice(list.Type(), 0, 0))
+			return
 		}
-		db = db.Order(order, true)
 	}
-	err = db.Find(modelListPtr).Error
+	err = db.Limit(pageSize).Offset(offset).Find(modelListPtr).Error
 	return
 }
@@ -0,0 +1,317 @@
+package agent
+
+// 批量写入，批量操作均在一个事务中执行
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strings"
+)
+
+const (
+	defaultBatchChunkSize = 500
+	// mysql与postgres单条sql最多支持65535个占位符
+	maxBatchPlaceholders = 65535
+)
+
+// listElems 将对象切片指针展开为对象指针列表，支持*[]*T与*[]T
+func listElems(modelListPtr interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(modelListPtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("modelListPtr must be a pointer to slice")
+	}
+	v = v.Elem()
+	elems := make([]interface{}, v.Len())
+	for i := range elems {
+		e := v.Index(i)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		}
+		elems[i] = e.Interface()
+	}
+	return elems, nil
+}
+
+// CreateEntities 批量插入，每chunkSize条数据生成一条insert语句，chunkSize为0时使用默认值500
+//	插入前对每条数据执行Checker、AgentChecker检查，ID由数据库生成并回填
+//	直接执行insert语句，不执行gorm的create回调及BeforeCreate、AfterCreate等model hook
+//	postgres通过returning获取ID，sqlite及innodb_autoinc_lock_mode为0、1的mysql按自增步长推算ID
+//	innodb_autoinc_lock_mode=2(mysql 8默认)时并发插入的ID不保证连续，无法推算，
+//	默认仍按chunkSize多行插入但不回填ID；ctx通过WithIDBackfill创建时逐条插入并回填ID，速度明显变慢
+//	EntityRelation需逐条使用CreateRelation以检查relation类型，否则返回ErrRelationBypass
+//	sql like:
+//		insert into {schema_name}
+//			(column1, column2, ...)
+//		values
+//			({value1}, {value2}, ...),
+//			({value1}, {value2}, ...)
+func (ma *MetaAgent) CreateEntities(ctx context.Context, modelListPtr interface{}, chunkSize int) error {
+	elems, err := listElems(modelListPtr)
+	if err != nil {
+		return err
+	}
+	for _, mPtr := range elems {
+		if _, ok := mPtr.(*EntityRelation); ok {
+			return ErrRelationBypass
+		}
+		if err = ma.checkEntity(ctx, mPtr); err != nil {
+			return err
+		}
+		if s, ok := mPtr.(Schema); ok {
+			s.SetID(0)
+		}
+		if v, ok := mPtr.(Versioner); ok {
+			v.SetVersion(0)
+		}
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultBatchChunkSize
+	}
+
+	return ma.WithTransaction(ctx, func(ctx context.Context) error {
+		db := ma.GetDB(ctx)
+		now := gorm.NowFunc()
+		for _, mPtr := range elems {
+			scope := db.NewScope(mPtr)
+			for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+				if f, ok := scope.FieldByName(name); ok && f.IsBlank {
+					if err := f.Set(now); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		// 单条sql的占位符数量不能超过上限
+		if columns := len(db.NewScope(elems[0]).Fields()); columns*chunkSize > maxBatchPlaceholders {
+			chunkSize = maxBatchPlaceholders / columns
+		}
+		step := int64(1)
+		if db.Dialect().GetName() == "mysql" {
+			var err error
+			if step, err = mysqlAutoIncStep(db); err != nil {
+				return err
+			}
+			if step == 0 && isWithIDBackfill(ctx) {
+				chunkSize = 1
+				step = 1
+			}
+		}
+		for start := 0; start < len(elems); start += chunkSize {
+			end := start + chunkSize
+			if end > len(elems) {
+				end = len(elems)
+			}
+			if err := insertChunk(db, elems[start:end], step); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type withIDBackfillKey struct{}
+
+// WithIDBackfill 返回的ctx用于CreateEntities时总是回填ID，
+// innodb_autoinc_lock_mode=2的mysql上会退化为逐条插入
+func WithIDBackfill(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withIDBackfillKey{}, true)
+}
+
+func isWithIDBackfill(ctx context.Context) bool {
+	with, _ := ctx.Value(withIDBackfillKey{}).(bool)
+	return with
+}
+
+// mysqlAutoIncStep 返回自增ID的步长，innodb_autoinc_lock_mode=2时一条insert生成的ID可能不连续，返回0
+func mysqlAutoIncStep(db *gorm.DB) (int64, error) {
+	var lockMode, step int64
+	row := db.Raw("SELECT @@innodb_autoinc_lock_mode, @@auto_increment_increment").Row()
+	if err := row.Scan(&lockMode, &step); err != nil {
+		return 0, err
+	}
+	if lockMode == 2 {
+		return 0, nil
+	}
+	return step, nil
+}
+
+// insertChunk 用一条insert语句插入一组数据并回填ID，step为连续自增ID的步长，为0时不回填
+func insertChunk(db *gorm.DB, elems []interface{}, step int64) error {
+	scopes := make([]*gorm.Scope, len(elems))
+	for i, mPtr := range elems {
+		scopes[i] = db.NewScope(mPtr)
+	}
+
+	// 有默认值且所有数据都为空的列交给数据库生成
+	var fieldNames, columns []string
+	for _, field := range scopes[0].Fields() {
+		if !field.IsNormal || field.IsIgnored || field.IsPrimaryKey {
+			continue
+		}
+		if field.HasDefaultValue {
+			allBlank := true
+			for _, scope := range scopes {
+				if f, _ := scope.FieldByName(field.Name); !f.IsBlank {
+					allBlank = false
+					break
+				}
+			}
+			if allBlank {
+				continue
+			}
+		}
+		fieldNames = append(fieldNames, field.Name)
+		columns = append(columns, scopes[0].Quote(field.DBName))
+	}
+
+	scope := db.NewScope(elems[0])
+	rows := make([]string, len(scopes))
+	placeholders := make([]string, len(fieldNames))
+	for i, s := range scopes {
+		for j, name := range fieldNames {
+			f, _ := s.FieldByName(name)
+			placeholders[j] = scope.AddToVars(f.Field.Interface())
+		}
+		rows[i] = "(" + strings.Join(placeholders, ",") + ")"
+	}
+	// Raw会把通用方言的占位符替换为?
+	scope.Raw(fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		scope.QuotedTableName(), strings.Join(columns, ","), strings.Join(rows, ",")))
+	sql := scope.SQL
+
+	primaryField := scope.PrimaryField()
+	if primaryField == nil {
+		_, err := scope.SQLDB().Exec(sql, scope.SQLVars...)
+		return err
+	}
+	ids := make([]int64, 0, len(elems))
+	switch db.Dialect().GetName() {
+	case "postgres":
+		sql += " RETURNING " + scope.Quote(primaryField.DBName)
+		rs, err := scope.SQLDB().Query(sql, scope.SQLVars...)
+		if err != nil {
+			return err
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var id int64
+			if err = rs.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err = rs.Err(); err != nil {
+			return err
+		}
+	default:
+		res, err := scope.SQLDB().Exec(sql, scope.SQLVars...)
+		if err != nil || step == 0 {
+			return err
+		}
+		lastID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		// mysql返回第一条数据的ID，sqlite返回最后一条数据的ID
+		firstID := lastID
+		if db.Dialect().GetName() == "sqlite3" {
+			firstID = lastID - int64(len(elems)-1)*step
+		}
+		for i := range elems {
+			ids = append(ids, firstID+int64(i)*step)
+		}
+	}
+	for i, s := range scopes {
+		if i < len(ids) {
+			if err := s.SetColumn(primaryField.Name, ids[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateEntitiesByID 批量全量更新，对每条数据执行Checker、AgentChecker检查后逐条执行UpdateEntityByID
+//	任意一条失败(包括ErrVersionConflict)则全部回滚
+func (ma *MetaAgent) UpdateEntitiesByID(ctx context.Context, modelListPtr interface{}) error {
+	elems, err := listElems(modelListPtr)
+	if err != nil {
+		return err
+	}
+	for _, mPtr := range elems {
+		if _, ok := mPtr.(*EntityRelation); ok {
+			return ErrRelationBypass
+		}
+		if err = ma.checkEntity(ctx, mPtr); err != nil {
+			return err
+		}
+	}
+	return ma.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, mPtr := range elems {
+			if err := ma.UpdateEntityByID(ctx, mPtr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteEntitiesByID 批量删除，如果model实现了软删除则逐条执行软删除，
+//	否则每chunkSize个ID生成一条delete语句，chunkSize为0时使用默认值500
+//	在同一事务中按schema的删除策略处理relation
+//	sql like:
+//		delete from {schema_name}
+//		where id in ({id1}, {id2}, ...)
+func (ma *MetaAgent) DeleteEntitiesByID(ctx context.Context, modelListPtr interface{}, chunkSize int) error {
+	elems, err := listElems(modelListPtr)
+	if err != nil || len(elems) == 0 {
+		return err
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultBatchChunkSize
+	}
+	return ma.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, ok := elems[0].(SoftDeleter); ok {
+			for _, mPtr := range elems {
+				if err := ma.DeleteEntityByID(ctx, mPtr); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		db := ma.GetDB(ctx)
+		// 使用空对象，避免gorm把第一条数据的主键加入删除条件
+		blank := reflect.New(reflect.TypeOf(elems[0]).Elem()).Interface()
+		ids := make([]interface{}, len(elems))
+		entityIDs := make([]int64, 0, len(elems))
+		for i, mPtr := range elems {
+			ids[i] = db.NewScope(mPtr).PrimaryKeyValue()
+			if id, ok := ids[i].(int64); ok {
+				entityIDs = append(entityIDs, id)
+			}
+		}
+		if schemaName, policy := ma.deletePolicyOf(blank); policy != DeletePolicyNone {
+			if err := ma.applyDeletePolicy(ctx, schemaName, policy, entityIDs); err != nil {
+				return err
+			}
+		}
+		for start := 0; start < len(ids); start += chunkSize {
+			end := start + chunkSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			err := db.Unscoped().Where("id in (?)", ids[start:end]).Delete(blank).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testCheckedUser struct {
+	testUser
+}
+
+func (u *testCheckedUser) Check() error {
+	if u.Name == "" {
+		return errors.New("name can not be empty")
+	}
+	return nil
+}
+
+func TestMetaAgent_BatchEntities(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+
+	var users []*testUser
+	for i := 0; i < 7; i++ {
+		users = append(users, &testUser{Name: fmt.Sprintf("user%d", i), Age: i})
+	}
+	var total int
+	var err error
+	if err = ma.CreateEntities(ctx, &users, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i, u := range users {
+		if u.ID != int64(i+1) || u.CreatedAt.IsZero() {
+			t.Errorf("id or created_at not filled back: %+v", u)
+		}
+	}
+	if err, total = ma.QueryEntityListByStringCondition(ctx, &[]*testUser{}, 0, 0, "", false); err != nil || total != 7 {
+		t.Fatalf("got %d users, err %v", total, err)
+	}
+
+	for _, u := range users {
+		u.Age += 10
+	}
+	if err := ma.UpdateEntitiesByID(ctx, &users); err != nil {
+		t.Fatal(err)
+	}
+	var got testUser
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", users[6].ID); err != nil || got.Age != 16 {
+		t.Errorf("update not applied: %+v, err %v", got, err)
+	}
+
+	if err := ma.DeleteEntitiesByID(ctx, &users, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err, total = ma.QueryEntityListByStringCondition(ctx, &[]*testUser{}, 0, 0, "", false); err != nil || total != 0 {
+		t.Errorf("got %d users after delete, err %v", total, err)
+	}
+
+	checked := []testCheckedUser{{testUser{Name: "ok"}}, {testUser{Name: ""}}}
+	if err := ma.CreateEntities(ctx, &checked, 0); err == nil {
+		t.Error("check failure should abort batch create")
+	}
+	if err, total = ma.QueryEntityListByStringCondition(ctx, &[]*testUser{}, 0, 0, "", false); total != 0 {
+		t.Errorf("batch should be atomic, got %d users", total)
+	}
+}
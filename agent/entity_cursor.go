@@ -0,0 +1,162 @@
+package agent
+
+// 基于游标(keyset)的分页查询
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"reflect"
+)
+
+const defaultCursorKey = "id"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorPage 游标分页的翻页游标，为空表示没有下一页/上一页
+type CursorPage struct {
+	Next string `json:"next_cursor,omitempty"`
+	Prev string `json:"prev_cursor,omitempty"`
+}
+
+// cursor 游标内容，序列化后base64编码对外不透明
+type cursor struct {
+	// 排序列，用于校验游标与查询是否匹配
+	Key string `json:"k"`
+	// 上一页最后一条(或下一页第一条)数据的排序列值
+	Value interface{} `json:"v"`
+	// true向前翻页
+	Prev bool `json:"p,omitempty"`
+}
+
+func encodeCursor(c *cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err = d.Decode(&c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	// 整数游标还原为int64，避免float64丢失精度
+	if n, ok := c.Value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			c.Value = i
+		} else if f, err := n.Float64(); err == nil {
+			c.Value = f
+		}
+	}
+	return &c, nil
+}
+
+// QueryEntityListByCursor 通过游标进行分页查询，避免大偏移量offset的性能问题以及翻页期间数据变化导致的重复、遗漏
+//	key为排序列，必须是唯一列，为空时使用id；cursor为空时查询第一页
+//	sql like:
+//		select (column1, column2,...) from {schema_name}
+//		where {where...} and {key} > {cursor_value}
+//		order by {key} [desc]
+//		limit {pageSize + 1}
+func (ma *MetaAgent) QueryEntityListByCursor(ctx context.Context, modelListPtr interface{}, pageSize int,
+	cursorStr string, key string, desc bool, filter ...interface{}) (*CursorPage, error) {
+	if pageSize <= 0 {
+		return nil, errors.New("page size must be positive")
+	}
+	if key == "" {
+		key = defaultCursorKey
+	}
+	db := ma.GetReadDB(ctx)
+	elemPtr := newListElem(modelListPtr)
+	key, err := columnName(db.NewScope(elemPtr), key)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *cursor
+	if cursorStr != "" {
+		var err error
+		if c, err = decodeCursor(cursorStr); err != nil {
+			return nil, err
+		}
+		if c.Key != key {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// 添加过滤条件
+	if len(filter) > 0 {
+		db = db.Where(filter[0], filter[1:]...)
+	}
+	// 向前翻页时反向排序，查出后再反转
+	prev := c != nil && c.Prev
+	asc := desc == prev
+	quotedKey := db.NewScope(elemPtr).Quote(key)
+	if c != nil {
+		if asc {
+			db = db.Where(quotedKey+" > ?", c.Value)
+		} else {
+			db = db.Where(quotedKey+" < ?", c.Value)
+		}
+	}
+	order := quotedKey
+	if !asc {
+		order += " desc"
+	}
+	if err := db.Order(order).Limit(pageSize + 1).Find(modelListPtr).Error; err != nil {
+		return nil, err
+	}
+
+	list := reflect.ValueOf(modelListPtr).Elem()
+	hasMore := list.Len() > pageSize
+	if hasMore {
+		list.Set(list.Slice(0, pageSize))
+	}
+	if prev {
+		for i, j := 0, list.Len()-1; i < j; i, j = i+1, j-1 {
+			vi, vj := list.Index(i).Interface(), list.Index(j).Interface()
+			list.Index(i).Set(reflect.ValueOf(vj))
+			list.Index(j).Set(reflect.ValueOf(vi))
+		}
+	}
+
+	page := &CursorPage{}
+	if list.Len() == 0 {
+		return page, nil
+	}
+	first := cursorValue(db, list.Index(0), key)
+	last := cursorValue(db, list.Index(list.Len()-1), key)
+	// 向后翻页时: 有更多数据才有下一页，带游标才有上一页；向前翻页时相反
+	if prev || hasMore {
+		page.Next = encodeCursor(&cursor{Key: key, Value: last})
+	}
+	if (prev && hasMore) || (!prev && c != nil) {
+		page.Prev = encodeCursor(&cursor{Key: key, Value: first, Prev: true})
+	}
+	return page, nil
+}
+
+func cursorValue(db *gorm.DB, elem reflect.Value, key string) interface{} {
+	if elem.Kind() != reflect.Ptr {
+		elem = elem.Addr()
+	}
+	field, _ := db.NewScope(elem.Interface()).FieldByName(key)
+	return field.Field.Interface()
+}
+
+// newListElem 创建对象切片元素类型的空对象指针，支持*[]*T与*[]T
+func newListElem(modelListPtr interface{}) interface{} {
+	t := reflect.TypeOf(modelListPtr).Elem().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t).Interface()
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func userNames(list []*testUser) []string {
+	names := make([]string, len(list))
+	for i, u := range list {
+		names[i] = u.Name
+	}
+	return names
+}
+
+func TestMetaAgent_QueryEntityListByCursor(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i), Age: i % 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// id desc: u5 u4 | u3 u2 | u1
+	var list []*testUser
+	page, err := ma.QueryEntityListByCursor(ctx, &list, 2, "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(userNames(list)) != "[u5 u4]" || page.Next == "" || page.Prev != "" {
+		t.Fatalf("first page got %v, %+v", userNames(list), page)
+	}
+
+	list = nil
+	page, err = ma.QueryEntityListByCursor(ctx, &list, 2, page.Next, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(userNames(list)) != "[u3 u2]" || page.Next == "" || page.Prev == "" {
+		t.Fatalf("second page got %v, %+v", userNames(list), page)
+	}
+	second := *page
+
+	list = nil
+	page, err = ma.QueryEntityListByCursor(ctx, &list, 2, second.Next, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(userNames(list)) != "[u1]" || page.Next != "" || page.Prev == "" {
+		t.Fatalf("last page got %v, %+v", userNames(list), page)
+	}
+
+	list = nil
+	page, err = ma.QueryEntityListByCursor(ctx, &list, 2, second.Prev, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(userNames(list)) != "[u5 u4]" || page.Next == "" || page.Prev != "" {
+		t.Fatalf("prev page got %v, %+v", userNames(list), page)
+	}
+
+	// 带过滤条件正序
+	list = nil
+	page, err = ma.QueryEntityListByCursor(ctx, &list, 2, "", "id", false, "age=?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(userNames(list)) != "[u1 u3]" || page.Next == "" {
+		t.Fatalf("filtered page got %v, %+v", userNames(list), page)
+	}
+
+	if _, err = ma.QueryEntityListByCursor(ctx, &list, 2, "not a cursor", "", true); err != ErrInvalidCursor {
+		t.Errorf("bad cursor got err %v", err)
+	}
+	if _, err = ma.QueryEntityListByCursor(ctx, &list, 2, page.Next, "name", true); err != ErrInvalidCursor {
+		t.Errorf("cursor of another key got err %v", err)
+	}
+}
+
+func TestGetEntityListHandler_Cursor(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+
+	var names []string
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		path := "/entity/test_user/list?page_size=2&cursor=" + url.QueryEscape(cursor)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp struct {
+			Data struct {
+				List       []*testUser `json:"list"`
+				NextCursor string      `json:"next_cursor"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, userNames(resp.Data.List)...)
+		if cursor = resp.Data.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(names) != "[u3 u2 u1]" {
+		t.Errorf("got %v", names)
+	}
+}
@@ -2,21 +2,28 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/lucky-loki/orm/agent/utils"
 	"log"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
 )
 
 func registerEntityHandler(router gin.IRouter, ma *MetaAgent) {
 	group := router.Group("/entity/:schema_name")
 	group.POST("/create", createEntity(ma))
 	group.PUT("/by/id/:id", updateEntity(ma))
+	group.PATCH("/by/id/:id", patchEntity(ma))
 	group.DELETE("/by/id/:id", deleteEntity(ma))
 	group.GET("/by/id/:id", getEntityByID(ma))
+	group.GET("/by/id/:id/incoming", getEntityIncoming(ma))
+	group.GET("/by/id/:id/graph", getEntityGraph(ma))
 	group.GET("/list", getEntityList(ma))
+	group.GET("/aggregate", getEntityAggregate(ma))
 }
 
 func createEntity(ma *MetaAgent) gin.HandlerFunc {
@@ -71,10 +78,10 @@ func updateEntity(ma *MetaAgent) gin.HandlerFunc {
 			return
 		}
 
-		// 查询Entity
+		// 查询Entity，随后要写入，读主库
 		var entityDB interface{}
 		entityDB, _ = ma.GetModelPtr(schemaName)
-		ctx := context.Background()
+		ctx := WithPrimary(context.Background())
 		err = ma.QueryOneEntityByStringFilter(ctx, entityDB, "id=?", id)
 		if err != nil {
 			failLog(c, "查找该业务失败: %s", err)
@@ -84,6 +91,59 @@ func updateEntity(ma *MetaAgent) gin.HandlerFunc {
 		// 更新Entity
 		entity.(Schema).SetID(id)
 		err = ma.UpdateEntityByID(ctx, entity)
+		if errors.Is(err, ErrVersionConflict) {
+			failLogWithStatus(c, http.StatusConflict, "更新业务失败: %s", err)
+			return
+		}
+		if err != nil {
+			failLog(c, "更新业务失败: %s", err)
+			return
+		}
+		success(c, nil)
+	}
+}
+
+// patchEntity 请求体为JSON merge patch，只更新出现的字段
+func patchEntity(ma *MetaAgent) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 解析ID
+		var id int64
+		var err error
+		id, err = strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			failLog(c, "id 错误")
+			return
+		}
+
+		// 创建EntityModel
+		schemaName := c.Param("schema_name")
+		entityDB, exist := ma.GetModelPtr(schemaName)
+		if !exist {
+			failLog(c, "schema不存在: '%s'", schemaName)
+			return
+		}
+
+		// 读取请求参数
+		var patch []byte
+		if patch, err = c.GetRawData(); err != nil {
+			failLog(c, "解析请求失败")
+			return
+		}
+
+		// 查询Entity，随后要写入，读主库
+		ctx := WithPrimary(context.Background())
+		err = ma.QueryOneEntityByStringFilter(ctx, entityDB, "id=?", id)
+		if err != nil {
+			failLog(c, "查找该业务失败: %s", err)
+			return
+		}
+
+		// 部分更新Entity
+		err = ma.MergePatchEntityByID(ctx, entityDB, patch)
+		if errors.Is(err, ErrVersionConflict) {
+			failLogWithStatus(c, http.StatusConflict, "更新业务失败: %s", err)
+			return
+		}
 		if err != nil {
 			failLog(c, "更新业务失败: %s", err)
 			return
@@ -111,7 +171,7 @@ func deleteEntity(ma *MetaAgent) gin.HandlerFunc {
 			return
 		}
 
-		ctx := context.Background()
+		ctx := WithPrimary(context.Background())
 		err = ma.QueryOneEntityByStringFilter(ctx, entityDB, "id=?", id)
 		if err != nil {
 			failLog(c, "查询Entity失败: %s", err)
@@ -119,6 +179,10 @@ func deleteEntity(ma *MetaAgent) gin.HandlerFunc {
 		}
 		// 删除Entity
 		err = ma.DeleteEntityByID(ctx, entityDB)
+		if errors.Is(err, ErrDeleteRestricted) {
+			failLogWithStatus(c, http.StatusConflict, "删除Entity失败: %s", err)
+			return
+		}
 		if err != nil {
 			failLog(c, "删除Entity失败: %s", err)
 			return
@@ -135,12 +199,23 @@ type relationFilterParam struct {
 	RelationPageSize int                          `form:"page_size"`
 	RelationPage     int                          `form:"page"`
 	RelationFilter   map[string]map[string]string `form:"relation_filter"`
+	// 是否包含已禁用的relation
+	IncludeDisabled bool `form:"include_disabled"`
+}
+
+// relationContext 根据参数决定是否包含已禁用的relation
+func (req *relationFilterParam) relationContext(ctx context.Context) context.Context {
+	if req.IncludeDisabled {
+		return WithDisabledRelations(ctx)
+	}
+	return ctx
 }
 
 type getEntityByIDResp struct {
-	Entity          interface{} `json:"entity"`
-	Relation        interface{} `json:"relation"`
-	RelationContent interface{} `json:"relation_content"`
+	Entity               interface{} `json:"entity"`
+	Relation             interface{} `json:"relation"`
+	RelationContent      interface{} `json:"relation_content"`
+	TypedRelationContent interface{} `json:"typed_relation_content,omitempty"`
 }
 
 func getEntityByID(ma *MetaAgent) gin.HandlerFunc {
@@ -180,25 +255,156 @@ func getEntityByID(ma *MetaAgent) gin.HandlerFunc {
 				SourceSchemaName: req.SourceSchemaName,
 				SourceEntityID:   req.SourceEntityId,
 			}
-			relationList, err := ma.ListSourceEntityRelations(ctx, query, req.RelationPageSize, req.RelationPage,
+			relationList, err := ma.ListSourceEntityRelations(req.relationContext(ctx), query, req.RelationPageSize, req.RelationPage,
 				req.Relations, req.RelationFilter)
+			if IsIdentifierError(err) {
+				failLogWithStatus(c, http.StatusBadRequest, "查询关系参数错误: %s", err)
+				return
+			}
 			if err != nil {
 				failLog(c, "查询关系出错: %s", err)
 				return
 			}
+			if relationList != nil {
+				resp.Relation = relationList.Relation
+				resp.RelationContent = relationList.RelationContent
+				if relationList.TypedRelationContent != nil {
+					resp.TypedRelationContent = relationList.TypedRelationContent
+				}
+			}
+		}
+
+		success(c, &resp)
+	}
+}
+
+type getEntityIncomingResp struct {
+	Relation             interface{} `json:"relation"`
+	RelationContent      interface{} `json:"relation_content"`
+	TypedRelationContent interface{} `json:"typed_relation_content,omitempty"`
+}
+
+// 列出指向该entity的source entity，relations、relation_filter的key为source schema
+func getEntityIncoming(ma *MetaAgent) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 解析参数
+		var err error
+		var req relationFilterParam
+		if err = c.ShouldBindUri(&req); err != nil {
+			failLog(c, "解析参数出错: %s", err)
+			return
+		}
+		if err = c.ShouldBindQuery(&req); err != nil {
+			failLog(c, "解析参数出错: %s", err)
+			return
+		}
+
+		// 查询relation
+		var resp getEntityIncomingResp
+		query := &EntityRelation{
+			TargetSchemaName: req.SourceSchemaName,
+			TargetEntityID:   req.SourceEntityId,
+		}
+		relationList, err := ma.ListTargetEntityRelations(req.relationContext(context.Background()), query,
+			req.RelationPageSize, req.RelationPage, req.Relations, req.RelationFilter)
+		if IsIdentifierError(err) {
+			failLogWithStatus(c, http.StatusBadRequest, "查询关系参数错误: %s", err)
+			return
+		}
+		if err != nil {
+			failLog(c, "查询关系出错: %s", err)
+			return
+		}
+		if relationList != nil {
 			resp.Relation = relationList.Relation
 			resp.RelationContent = relationList.RelationContent
+			if relationList.TypedRelationContent != nil {
+				resp.TypedRelationContent = relationList.TypedRelationContent
+			}
 		}
-
 		success(c, &resp)
 	}
 }
 
+const maxGraphDepth = 10
+
+type getEntityGraphReq struct {
+	SchemaName string `uri:"schema_name"`
+	ID         int64  `uri:"id"`
+
+	// 最大跳数，默认为3，最大为10
+	MaxDepth int `form:"max_depth"`
+	// out、in或both，默认为out
+	Direction     string   `form:"direction"`
+	Schemas       []string `form:"schemas"`
+	RelationTypes []string `form:"relation_types"`
+	// 是否包含已禁用的relation
+	IncludeDisabled bool `form:"include_disabled"`
+	// 是否使用递归CTE
+	CTE bool `form:"cte"`
+}
+
+// 从该entity出发多跳遍历relation
+func getEntityGraph(ma *MetaAgent) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 解析参数
+		var err error
+		var req getEntityGraphReq
+		if err = c.ShouldBindUri(&req); err != nil {
+			failLog(c, "解析参数出错: %s", err)
+			return
+		}
+		if err = c.ShouldBindQuery(&req); err != nil {
+			failLog(c, "解析参数出错: %s", err)
+			return
+		}
+		if req.MaxDepth < 0 || req.MaxDepth > maxGraphDepth {
+			failLogWithStatus(c, http.StatusBadRequest, "max_depth 必须在 0 到 %d 之间", maxGraphDepth)
+			return
+		}
+		opts := &GraphOptions{
+			MaxDepth:      req.MaxDepth,
+			Direction:     GraphDirection(req.Direction),
+			Schemas:       req.Schemas,
+			RelationTypes: req.RelationTypes,
+			UseCTE:        req.CTE,
+		}
+		if err = opts.check(); err != nil {
+			failLogWithStatus(c, http.StatusBadRequest, "解析参数出错: %s", err)
+			return
+		}
+
+		ctx := context.Background()
+		if req.IncludeDisabled {
+			ctx = WithDisabledRelations(ctx)
+		}
+		graph, err := ma.TraverseRelations(ctx, req.SchemaName, req.ID, opts)
+		if IsIdentifierError(err) {
+			failLogWithStatus(c, http.StatusBadRequest, "解析参数出错: %s", err)
+			return
+		}
+		if err != nil {
+			failLog(c, "遍历关系出错: %s", err)
+			return
+		}
+		success(c, graph)
+	}
+}
+
+const defaultCursorPageSize = 20
+
 type getEntityListReq struct {
 	SearchField string `form:"search_field"`
 	Search      string `form:"search"`
 	Page        int    `form:"page"`
 	PageSize    int    `form:"page_size"`
+
+	// 过滤表达式，如 age>=18 and status in ("A","B")
+	Filter string `form:"filter"`
+	// 排序字段，逗号分隔，-表示倒序，如 -created_at,name
+	Sort string `form:"sort"`
+	// 为false时不统计总数，默认为true
+	WithTotal *bool `form:"with_total"`
 }
 
 type getEntityListResp struct {
@@ -206,6 +412,17 @@ type getEntityListResp struct {
 	Total int         `json:"total"`
 }
 
+// with_total=false时不返回total
+type getEntityPageResp struct {
+	List interface{} `json:"list"`
+}
+
+// 带cursor参数时使用游标分页，cursor为空表示第一页，此时忽略page且不返回total
+type getEntityCursorListResp struct {
+	List interface{} `json:"list"`
+	*CursorPage
+}
+
 func getEntityList(ma *MetaAgent) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 解析参数
@@ -224,16 +441,72 @@ func getEntityList(ma *MetaAgent) gin.HandlerFunc {
 			return
 		}
 
-		// 查询塞值
-		var resp getEntityListResp
-		ctx := context.Background()
+		// 构建search过滤条件
+		var filter interface{}
 		if req.SearchField != "" {
-			filter, _ := ma.GetModelPtr(schemaName)
+			filter, _ = ma.GetModelPtr(schemaName)
 			err = utils.SetValueByTag(filter, req.SearchField, req.Search, "json")
 			if err != nil {
 				failLog(c, "search_field and search value error")
 				return
 			}
+		}
+
+		// 带filter、sort参数时使用查询构造器
+		ctx := context.Background()
+		var q *Query
+		if req.Filter != "" || req.Sort != "" {
+			q, err = newListQuery(ma, schemaName, filter, req)
+			if err != nil {
+				failLogWithStatus(c, http.StatusBadRequest, "解析参数失败: %s", err)
+				return
+			}
+		}
+
+		// 游标分页
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			if req.Sort != "" {
+				failLogWithStatus(c, http.StatusBadRequest, "cursor 不支持 sort 参数")
+				return
+			}
+			var filters []interface{}
+			if q != nil {
+				filters, err = q.Filter(ma.GetReadDB(ctx))
+				if err != nil {
+					failLogWithStatus(c, http.StatusBadRequest, "解析参数失败: %s", err)
+					return
+				}
+			} else if filter != nil {
+				filters = append(filters, filter)
+			}
+			if req.PageSize == 0 {
+				req.PageSize = defaultCursorPageSize
+			}
+			var resp getEntityCursorListResp
+			resp.CursorPage, err = ma.QueryEntityListByCursor(ctx, list, req.PageSize, cursor, "id", true, filters...)
+			if err != nil {
+				failLog(c, "查询EntityList失败: %s", err)
+				return
+			}
+			resp.List = list
+			success(c, &resp)
+			return
+		}
+
+		// 查询塞值
+		withTotal := req.WithTotal == nil || *req.WithTotal
+		if !withTotal {
+			ctx = WithoutTotal(ctx)
+		}
+		var resp getEntityListResp
+		if q != nil {
+			if withTotal {
+				resp.Total, err = ma.CountEntityByQuery(ctx, q)
+			}
+			if err == nil && (!withTotal || resp.Total > 0) {
+				err = ma.QueryEntityListByQuery(ctx, list, q)
+			}
+		} else if filter != nil {
 			err, resp.Total = ma.QueryEntityListByStructCondition(ctx, list,
 				req.PageSize, req.Page, "id", true, filter)
 		} else {
@@ -241,15 +514,171 @@ func getEntityList(ma *MetaAgent) gin.HandlerFunc {
 				req.PageSize, req.Page, "id", true)
 		}
 
+		if IsIdentifierError(err) {
+			failLogWithStatus(c, http.StatusBadRequest, "查询参数错误: %s", err)
+			return
+		}
 		if err != nil {
 			failLog(c, "查询EntityList失败: %s", err)
 			return
 		}
+		if !withTotal {
+			success(c, &getEntityPageResp{List: list})
+			return
+		}
 		resp.List = list
 		success(c, &resp)
 	}
 }
 
+// newListQuery 根据filter、sort、search及分页参数构建列表查询，未指定sort时按id倒序
+func newListQuery(ma *MetaAgent, schemaName string, search interface{}, req getEntityListReq) (*Query, error) {
+	model, _ := ma.GetModelPtr(schemaName)
+	q := ma.NewQuery(schemaName)
+	if req.Filter != "" {
+		cond, err := ParseFilter(model, req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		q.Where(cond)
+	}
+	if search != nil {
+		sf, _ := utils.FieldByTag(search, req.SearchField, "json")
+		q.Where(Eq(sf.Name, reflect.ValueOf(search).Elem().FieldByIndex(sf.Index).Interface()))
+	}
+	if req.Sort == "" {
+		q.OrderBy("id", true)
+	} else {
+		fields, err := ParseSort(model, req.Sort)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			q.OrderBy(f.Column, f.Desc)
+		}
+	}
+	if req.PageSize > 0 {
+		if req.Page == 0 {
+			req.Page = 1
+		}
+		q.Limit(req.PageSize).Offset(req.PageSize * (req.Page - 1))
+	}
+	// 有json tag但没有对应列的字段(如gorm:"-")在这里报错，而不是在执行查询时
+	if _, _, err := q.Build(ma.db); err != nil {
+		return nil, err
+	}
+	scope := ma.db.NewScope(model)
+	for _, o := range q.orders {
+		if _, err := columnName(scope, o.column); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+type getEntityAggregateReq struct {
+	// 聚合项，如 count,sum(age)
+	Agg string `form:"agg" binding:"required"`
+	// 分组字段，逗号分隔
+	GroupBy string `form:"group_by"`
+	Filter  string `form:"filter"`
+	// 排序字段，可以是分组字段或聚合结果列名，如 -count,name
+	Sort     string `form:"sort"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+type getEntityAggregateResp struct {
+	List []map[string]interface{} `json:"list"`
+}
+
+// 聚合结果列名为 {func}_{column}，count不带字段时为count
+func getEntityAggregate(ma *MetaAgent) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 解析参数
+		var err error
+		var req getEntityAggregateReq
+		if err = c.ShouldBindQuery(&req); err != nil {
+			failLogWithStatus(c, http.StatusBadRequest, "解析参数失败: %s", err)
+			return
+		}
+		schemaName := c.Param("schema_name")
+		model, exist := ma.GetModelPtr(schemaName)
+		if !exist {
+			failLog(c, "schema 不存在: '%s'", schemaName)
+			return
+		}
+
+		// 构建聚合查询
+		q, err := newAggregateQuery(ma, schemaName, model, req)
+		if err != nil {
+			failLogWithStatus(c, http.StatusBadRequest, "解析参数失败: %s", err)
+			return
+		}
+
+		var resp getEntityAggregateResp
+		resp.List, err = ma.AggregateEntity(context.Background(), q)
+		if IsIdentifierError(err) {
+			failLogWithStatus(c, http.StatusBadRequest, "解析参数失败: %s", err)
+			return
+		}
+		if err != nil {
+			failLog(c, "聚合查询失败: %s", err)
+			return
+		}
+		success(c, &resp)
+	}
+}
+
+// newAggregateQuery 根据聚合、分组、过滤、排序及分页参数构建聚合查询
+func newAggregateQuery(ma *MetaAgent, schemaName string, model interface{}, req getEntityAggregateReq) (*AggregateQuery, error) {
+	aggs, err := ParseAggregates(model, req.Agg)
+	if err != nil {
+		return nil, err
+	}
+	q := ma.NewQuery(schemaName)
+	if req.Filter != "" {
+		cond, err := ParseFilter(model, req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		q.Where(cond)
+	}
+	a := ma.NewAggregateQuery(q).Select(aggs...)
+	if req.GroupBy != "" {
+		fields, err := ParseSort(model, req.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if f.Desc {
+				return nil, errors.New("invalid group_by: " + req.GroupBy)
+			}
+			a.GroupBy(f.Column)
+		}
+	}
+	if req.Sort != "" {
+		for _, item := range strings.Split(req.Sort, ",") {
+			item = strings.TrimSpace(item)
+			fields, err := ParseSort(model, item)
+			if err != nil {
+				// 不是model字段时作为聚合结果列名，执行时校验
+				desc := strings.HasPrefix(item, "-")
+				q.OrderBy(strings.TrimPrefix(item, "-"), desc)
+				continue
+			}
+			q.OrderBy(fields[0].Column, fields[0].Desc)
+		}
+	}
+	if req.PageSize > 0 {
+		if req.Page == 0 {
+			req.Page = 1
+		}
+		q.Limit(req.PageSize).Offset(req.PageSize * (req.Page - 1))
+	}
+	return a, nil
+}
+
 // out put func
 
 const (
@@ -258,6 +687,10 @@ const (
 )
 
 func jsonOutPut(c *gin.Context, ret uint32, desc string, content interface{}) {
+	jsonOutPutWithStatus(c, http.StatusOK, ret, desc, content)
+}
+
+func jsonOutPutWithStatus(c *gin.Context, httpStatus int, ret uint32, desc string, content interface{}) {
 	result := map[string]interface{}{
 		"code": ret,
 		"msg":  desc,
@@ -265,7 +698,7 @@ func jsonOutPut(c *gin.Context, ret uint32, desc string, content interface{}) {
 	if content != nil {
 		result["data"] = content
 	}
-	c.JSON(http.StatusOK, result)
+	c.JSON(httpStatus, result)
 }
 
 func success(c *gin.Context, content interface{}) {
@@ -273,6 +706,10 @@ func success(c *gin.Context, content interface{}) {
 }
 
 func failLog(c *gin.Context, format string, a ...interface{}) {
+	failLogWithStatus(c, http.StatusOK, format, a...)
+}
+
+func failLogWithStatus(c *gin.Context, httpStatus int, format string, a ...interface{}) {
 	var desc string
 	if len(a) > 0 {
 		desc = fmt.Sprintf(format, a...)
@@ -280,5 +717,5 @@ func failLog(c *gin.Context, format string, a ...interface{}) {
 		desc = format
 	}
 	log.Println(desc)
-	jsonOutPut(c, retError, desc, nil)
+	jsonOutPutWithStatus(c, httpStatus, retError, desc, nil)
 }
@@ -0,0 +1,95 @@
+package agent
+
+// 按主键keyset分批遍历大结果集，避免一次性加载全部数据
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+const defaultIterateBatchSize = 500
+
+// ErrStopIterate 回调返回该错误时停止遍历，IterateEntity返回nil
+var ErrStopIterate = errors.New("stop iterate")
+
+// IterateEntity 按id升序分批遍历满足查询条件的数据，每条数据的对象指针依次传给fn
+//	batchSize为0时使用默认值，Query的排序、分页、投影被忽略
+//	每批查询前、每次回调前检查ctx，ctx取消时返回ctx.Err()
+//	ctx中有事务时使用事务连接，否则使用开始遍历时选定的一个读库
+//	sql like:
+//		select (column1, column2,...) from {schema_name}
+//		where {conds...} and id > {last_id}
+//		order by id
+//		limit {batchSize}
+func (ma *MetaAgent) IterateEntity(ctx context.Context, q *Query, batchSize int, fn func(mPtr interface{}) error) error {
+	if q.err != nil {
+		return q.err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultIterateBatchSize
+	}
+	// 整个遍历使用同一个读库，不同从库的复制进度不同，切换会导致跳过或重复数据
+	readDB := ma.GetReadDB(ctx)
+	var lastID interface{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db, err := q.apply(readDB, false)
+		if err != nil {
+			return err
+		}
+		quotedKey := db.NewScope(q.model).Quote("id")
+		if lastID != nil {
+			db = db.Where(quotedKey+" > ?", lastID)
+		}
+		listPtr, _ := ma.GetModelListPtr(q.schemaName)
+		if err = db.Order(quotedKey).Limit(batchSize).Find(listPtr).Error; err != nil {
+			return err
+		}
+
+		list := reflect.ValueOf(listPtr).Elem()
+		for i := 0; i < list.Len(); i++ {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			elem := list.Index(i)
+			if elem.Kind() != reflect.Ptr {
+				elem = elem.Addr()
+			}
+			if err = fn(elem.Interface()); err != nil {
+				if err == ErrStopIterate {
+					return nil
+				}
+				return err
+			}
+		}
+		if list.Len() < batchSize {
+			return nil
+		}
+		lastID = cursorValue(db, list.Index(list.Len()-1), "id")
+	}
+}
+
+// StreamEntity 在新的goroutine中执行IterateEntity，通过channel逐条返回对象指针
+//	遍历结束或出错后关闭数据channel，错误channel返回遍历结果后关闭
+//	调用方不再读取时应取消ctx，否则goroutine会一直阻塞
+//	ctx中有事务时，读取期间不要在同一事务上执行其他语句
+func (ma *MetaAgent) StreamEntity(ctx context.Context, q *Query, batchSize int) (<-chan interface{}, <-chan error) {
+	out := make(chan interface{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		errc <- ma.IterateEntity(ctx, q, batchSize, func(mPtr interface{}) error {
+			select {
+			case out <- mPtr:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return out, errc
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lucky-loki/orm"
+	"strings"
+	"testing"
+)
+
+func TestMetaAgent_IterateEntity(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 7; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i), Age: i % 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	err := ma.IterateEntity(ctx, ma.NewQuery("test_user").Where(Eq("age", 1)), 2, func(mPtr interface{}) error {
+		names = append(names, mPtr.(*testUser).Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[u1 u3 u5 u7]" {
+		t.Errorf("got %v", names)
+	}
+
+	// 停止遍历、回调出错
+	count := 0
+	err = ma.IterateEntity(ctx, ma.NewQuery("test_user"), 3, func(mPtr interface{}) error {
+		if count++; count == 4 {
+			return ErrStopIterate
+		}
+		return nil
+	})
+	if err != nil || count != 4 {
+		t.Errorf("stop got err %v, count %d", err, count)
+	}
+	errBoom := errors.New("boom")
+	err = ma.IterateEntity(ctx, ma.NewQuery("test_user"), 0, func(mPtr interface{}) error {
+		return errBoom
+	})
+	if err != errBoom {
+		t.Errorf("got %v, want %v", err, errBoom)
+	}
+
+	// 事务内遍历可以读到未提交的数据
+	err = ma.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := ma.CreateEntity(ctx, &testUser{Name: "u8"}); err != nil {
+			return err
+		}
+		count = 0
+		return ma.IterateEntity(ctx, ma.NewQuery("test_user"), 5, func(mPtr interface{}) error {
+			count++
+			return nil
+		})
+	})
+	if err != nil || count != 8 {
+		t.Errorf("tx got err %v, count %d", err, count)
+	}
+}
+
+func TestMetaAgent_StreamEntity(t *testing.T) {
+	ma := newTestAgent(t)
+	for i := 1; i <= 5; i++ {
+		if err := ma.CreateEntity(context.Background(), &testUser{Name: fmt.Sprintf("u%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, errc := ma.StreamEntity(context.Background(), ma.NewQuery("test_user"), 2)
+	var names []string
+	for mPtr := range out {
+		names = append(names, mPtr.(*testUser).Name)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[u1 u2 u3 u4 u5]" {
+		t.Errorf("got %v", names)
+	}
+
+	// 读取一条后取消
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errc = ma.StreamEntity(ctx, ma.NewQuery("test_user"), 2)
+	<-out
+	cancel()
+	for range out {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMetaAgent_IterateEntity_Replica(t *testing.T) {
+	ma := newTestAgent(t)
+	// 两个从库数据不一致，遍历不能在批次之间切换从库
+	for _, name := range []string{"r0", "r1"} {
+		c := &orm.Config{Database: t.Name() + "_" + name}
+		replica, err := c.OpenSqlite()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer replica.Close()
+		if err = replica.AutoMigrate(new(testUser)).Error; err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			if err = replica.Create(&testUser{Name: name}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		ma.RegisterReplica(replica, 1)
+	}
+
+	var names []string
+	err := ma.IterateEntity(context.Background(), ma.NewQuery("test_user"), 1, func(mPtr interface{}) error {
+		names = append(names, mPtr.(*testUser).Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 || strings.Count(strings.Join(names, ","), names[0]) != 4 {
+		t.Errorf("iterate should read from one replica, got %v", names)
+	}
+}
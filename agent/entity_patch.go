@@ -0,0 +1,103 @@
+package agent
+
+// 部分更新
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"github.com/lucky-loki/orm/agent/utils"
+	"reflect"
+)
+
+// ErrZeroPrimaryKey 按主键更新时主键为零值，gorm不会生成where条件而更新全表
+var ErrZeroPrimaryKey = errors.New("primary key can not be zero")
+
+// 由数据库维护或用于定位记录的列，不能被部分更新修改
+var patchReadonlyColumns = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+	"version":    true,
+}
+
+// PatchEntityByID 部分更新，只更新fields指定的列，其余列保持不变
+//	fields为json tag名，映射规则同utils.SetValueByTag，id、created_at、updated_at、version会被忽略
+//	如果model实现了Versioner，同UpdateEntityByID进行乐观锁检查，主键为零值时返回ErrZeroPrimaryKey
+//	sql like:
+//		update {schema_name}
+//		set {field1}={value1}, {field2}={value2},...
+//		where id={id}
+func (ma *MetaAgent) PatchEntityByID(ctx context.Context, mPtr interface{}, fields ...string) error {
+	db := ma.GetDB(ctx)
+	if db.NewScope(mPtr).PrimaryKeyZero() {
+		return ErrZeroPrimaryKey
+	}
+	columns, err := patchColumns(db, mPtr, fields)
+	if err != nil {
+		return err
+	}
+	if v, ok := mPtr.(Versioner); ok {
+		return updateVersionedColumns(db, mPtr, v, columns)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	return db.Model(mPtr).Updates(columns).Error
+}
+
+// MergePatchEntityByID 按JSON merge patch(RFC 7396)部分更新，patch中出现的顶层字段会被更新，
+//	值为null的字段更新为零值，mPtr须为已从数据库查出的完整数据
+//	实现了Versioner的model可以在patch中携带version作为期望的版本号
+func (ma *MetaAgent) MergePatchEntityByID(ctx context.Context, mPtr interface{}, patch []byte) error {
+	var patchMap map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &patchMap); err != nil {
+		return err
+	}
+	var id int64
+	if s, ok := mPtr.(Schema); ok {
+		id = s.GetID()
+	}
+	fields := make([]string, 0, len(patchMap))
+	for field, raw := range patchMap {
+		sf, ok := utils.FieldByTag(mPtr, field, "json")
+		if !ok {
+			return errors.New("unknown field: " + field)
+		}
+		if string(raw) == "null" {
+			v := reflect.ValueOf(mPtr).Elem().FieldByIndex(sf.Index)
+			v.Set(reflect.Zero(v.Type()))
+		}
+		fields = append(fields, field)
+	}
+	if err := json.Unmarshal(patch, mPtr); err != nil {
+		return err
+	}
+	// patch中的id不能改变要更新的记录
+	if s, ok := mPtr.(Schema); ok {
+		s.SetID(id)
+	}
+	return ma.PatchEntityByID(ctx, mPtr, fields...)
+}
+
+// patchColumns 将json tag名映射为列名及当前值
+func patchColumns(db *gorm.DB, mPtr interface{}, fields []string) (map[string]interface{}, error) {
+	scope := db.NewScope(mPtr)
+	columns := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		sf, ok := utils.FieldByTag(mPtr, field, "json")
+		if !ok {
+			return nil, errors.New("unknown field: " + field)
+		}
+		f, ok := scope.FieldByName(sf.Name)
+		if !ok || !f.IsNormal {
+			return nil, errors.New("field is not a column: " + field)
+		}
+		if f.IsPrimaryKey || patchReadonlyColumns[f.DBName] {
+			continue
+		}
+		columns[f.DBName] = f.Field.Interface()
+	}
+	return columns, nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetaAgent_PatchEntityByID(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	u := &testUser{Name: "loki", Age: 18}
+	if err := ma.CreateEntity(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只更新age，name即使被修改也不会写入
+	patch := &testUser{Entity: Entity{ID: u.ID}, Name: "", Age: 20}
+	if err := ma.PatchEntityByID(ctx, patch, "age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.PatchEntityByID(ctx, patch, "not_exist"); err == nil {
+		t.Error("unknown field should fail")
+	}
+
+	var got testUser
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "loki" || got.Age != 20 {
+		t.Errorf("unexpected user after patch: %+v", got)
+	}
+
+	if err := ma.MergePatchEntityByID(ctx, &got, []byte(`{"name":"thor","age":null,"id":100}`)); err != nil {
+		t.Fatal(err)
+	}
+	got = testUser{}
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "thor" || got.Age != 0 {
+		t.Errorf("unexpected user after merge patch: %+v", got)
+	}
+}
+
+func TestMetaAgent_PatchEntityByID_ZeroID(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	for _, name := range []string{"loki", "thor"} {
+		if err := ma.CreateEntity(ctx, &testUser{Name: name, Age: 18}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ma.CreateEntity(ctx, &testDoc{Title: "doc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ma.PatchEntityByID(ctx, &testUser{Age: 99}, "age"); !errors.Is(err, ErrZeroPrimaryKey) {
+		t.Errorf("patch with zero id got err %v", err)
+	}
+	if err := ma.PatchEntityByID(ctx, &testDoc{Title: "all"}, "title"); !errors.Is(err, ErrZeroPrimaryKey) {
+		t.Errorf("versioned patch with zero id got err %v", err)
+	}
+
+	var total int64
+	if err := ma.GetDB(ctx).Model(&testUser{}).Where("age = ?", 99).Count(&total).Error; err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("patch with zero id should update nothing, %d rows updated", total)
+	}
+	if err := ma.GetDB(ctx).Model(&testDoc{}).Where("title = ?", "all").Count(&total).Error; err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("versioned patch with zero id should update nothing, %d rows updated", total)
+	}
+}
+
+func TestPatchEntityHandler(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	doc := &testDoc{Title: "v0"}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"title":"v1","version":0}`, http.StatusOK},
+		{`{"title":"stale","version":0}`, http.StatusConflict},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/entity/test_doc/by/id/1", bytes.NewBufferString(c.body))
+		router.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("PATCH %s got status %d, want %d", c.body, w.Code, c.status)
+		}
+	}
+
+	var got testDoc
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "v1" || got.Version != 1 {
+		t.Errorf("unexpected doc after patch: %+v", got)
+	}
+}
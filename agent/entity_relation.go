@@ -3,6 +3,8 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 const (
@@ -11,6 +13,7 @@ const (
 )
 
 var _ Schema = &EntityRelation{}
+var _ AgentChecker = &EntityRelation{}
 
 type EntityRelation struct {
 	Entity
@@ -20,6 +23,13 @@ type EntityRelation struct {
 	TargetSchemaName string `uri:"target_schema_name" json:"target_schema_name" gorm:"unique_index:uuid"`
 	TargetEntityID   int64  `uri:"target_entity_id" json:"target_entity_id" gorm:"unique_index:uuid"`
 	Content          string `json:"content" gorm:"type:blob"`
+
+	// relation类型，同一对entity可以存在多种类型的relation
+	// 已有的表需执行MigrateEntityRelation重建uuid索引
+	// 限制长度，保证utf8mb4下uuid索引不超过innodb 3072字节的上限
+	RelationType string `uri:"relation_type" json:"relation_type" gorm:"unique_index:uuid;size:64;not null;default:''"`
+	// ENABLE或DISABLE，创建时为空则为ENABLE
+	Status string `json:"status" gorm:"not null;default:'ENABLE'"`
 }
 
 func (er *EntityRelation) SchemaName() string {
@@ -43,68 +53,130 @@ func (er *EntityRelation) SetID(id int64) {
 	er.ID = id
 }
 
-// CheckRelation 检查relation是否合规
+// CheckRelation 检查relation是否合规，创建relation时由CreateEntity调用
+//	source、target的schema必须已注册且entity存在，status为空时设置为ENABLE
 func (relation *EntityRelation) Check(ctx context.Context, ma *MetaAgent) error {
 	if relation == nil {
 		return errors.New("relation can not be nil")
 	}
-	// 检查schema是否被注册
-	if _, exist := ma.pool[relation.SourceSchemaName]; !exist {
-		return errors.New("schema not register: " + relation.SourceSchemaName)
+	switch relation.Status {
+	case "":
+		relation.Status = EntityRelationStatusENABLE
+	case EntityRelationStatusENABLE, EntityRelationStatusDISABLE:
+	default:
+		return errors.New("invalid relation status: " + relation.Status)
 	}
-	if _, exist := ma.pool[relation.TargetSchemaName]; !exist {
-		return errors.New("schema not register: " + relation.TargetSchemaName)
+	if relation.SourceEntityID == 0 || relation.TargetEntityID == 0 {
+		return errors.New("source_entity_id and target_entity_id cannot be empty")
 	}
 
-	// 检查Entity是否存在
-	entityPtr, _ := ma.GetModelPtr(relation.SourceSchemaName)
-	entityPtr.(Schema).SetID(relation.SourceEntityID)
-	err := ma.QueryEntity(ctx, entityPtr)
-	if err != nil {
-		return err
+	// 检查schema是否被注册、Entity是否存在
+	if err := checkRelationEndpoint(ctx, ma, relation.SourceSchemaName, relation.SourceEntityID); err != nil {
+		return fmt.Errorf("source entity: %w", err)
+	}
+	if err := checkRelationEndpoint(ctx, ma, relation.TargetSchemaName, relation.TargetEntityID); err != nil {
+		return fmt.Errorf("target entity: %w", err)
 	}
-	entityPtr, _ = ma.GetModelPtr(relation.TargetSchemaName)
-	entityPtr.(Schema).SetID(relation.TargetEntityID)
-	err = ma.QueryEntity(ctx, entityPtr)
-	return err
+	return nil
 }
 
 // AddRelation 检查relation是否已经注册，如果检查通过则插入一条relation
+//	注册过relation类型时，检查类型是否允许以及基数是否满足
 //	sql like:
 //		insert into entity_relation
-//		(source_schema_name, source_entity_id, target_schema_name, target_entity_id, content)
+//		(source_schema_name, source_entity_id, target_schema_name, target_entity_id, relation_type, content)
 //		value
-//		({source_schema_name}, {source_entity_id}, {target_schema_name}, {target_entity_id}, {content})
+//		({source_schema_name}, {source_entity_id}, {target_schema_name}, {target_entity_id}, {relation_type}, {content})
 func (ma *MetaAgent) CreateRelation(ctx context.Context, relation *EntityRelation) (err error) {
-	return ma.CreateEntity(ctx, relation)
+	return ma.withRelationTypeCheck(ctx, relation, func(ctx context.Context) error {
+		return ma.CreateEntity(ctx, relation)
+	})
+}
+
+// relationUuidColumns EntityRelation的唯一索引uuid包含的列
+var relationUuidColumns = []string{"source_schema_name", "source_entity_id", "target_schema_name", "target_entity_id", "relation_type"}
+
+// UpsertRelation 插入relation，如果uuid已存在则更新content，relation类型检查同CreateRelation
+//	sql like:
+//		insert into entity_relation
+//		(source_schema_name, source_entity_id, target_schema_name, target_entity_id, relation_type, content)
+//		value
+//		({source_schema_name}, {source_entity_id}, {target_schema_name}, {target_entity_id}, {relation_type}, {content})
+//		on duplicate key update content=values(content)
+func (ma *MetaAgent) UpsertRelation(ctx context.Context, relation *EntityRelation) (err error) {
+	return ma.withRelationTypeCheck(ctx, relation, func(ctx context.Context) error {
+		return ma.upsertEntity(ctx, relation, &UpsertOptions{
+			ConflictColumns: relationUuidColumns,
+			UpdateColumns:   []string{"content", "updated_at"},
+		})
+	})
+}
+
+// withRelationTypeCheck 注册过relation类型时，在同一事务中检查relation类型后执行write
+func (ma *MetaAgent) withRelationTypeCheck(ctx context.Context, relation *EntityRelation, write txHandler) error {
+	if len(ma.relationTypes) == 0 {
+		return write(ctx)
+	}
+	return ma.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := ma.checkRelationType(ctx, relation); err != nil {
+			return err
+		}
+		return write(ctx)
+	})
 }
 
 func checkListSourceEntityRelationsQuery(ctx context.Context, q *EntityRelation, ma *MetaAgent) error {
 	if q.SourceSchemaName == "" || q.SourceEntityID == 0 {
 		return errors.New("source_schema_name and source_entity_id cannot be empty")
 	}
-	exist := false
-	// 检验schema是否被注册
-	if _, exist = ma.pool[q.SourceSchemaName]; !exist {
-		return errors.New("schema not register: " + q.SourceSchemaName)
+	if q.TargetSchemaName != "" {
+		if _, exist := ma.pool[q.TargetSchemaName]; !exist {
+			return &IdentifierError{Schema: q.TargetSchemaName}
+		}
 	}
+	return checkRelationEndpoint(ctx, ma, q.SourceSchemaName, q.SourceEntityID)
+}
 
-	if q.TargetSchemaName != "" {
-		_, exist = ma.pool[q.TargetSchemaName]
-		if !exist {
-			return errors.New("schema not register: " + q.TargetSchemaName)
+func checkListTargetEntityRelationsQuery(ctx context.Context, q *EntityRelation, ma *MetaAgent) error {
+	if q.TargetSchemaName == "" || q.TargetEntityID == 0 {
+		return errors.New("target_schema_name and target_entity_id cannot be empty")
+	}
+	if q.SourceSchemaName != "" {
+		if _, exist := ma.pool[q.SourceSchemaName]; !exist {
+			return &IdentifierError{Schema: q.SourceSchemaName}
 		}
 	}
+	return checkRelationEndpoint(ctx, ma, q.TargetSchemaName, q.TargetEntityID)
+}
+
+// checkRelationEndpoint 检验schema是否被注册、entity是否存在
+func checkRelationEndpoint(ctx context.Context, ma *MetaAgent, schemaName string, id int64) error {
+	entityPtr, exist := ma.GetModelPtr(schemaName)
+	if !exist {
+		return &IdentifierError{Schema: schemaName}
+	}
+	return ma.QueryOneEntityByStringFilter(ctx, entityPtr, "id=?", id)
+}
 
-	// 检验entity是否存在
-	entityPtr, _ := ma.GetModelPtr(q.SourceSchemaName)
-	err := ma.QueryEntity(ctx, entityPtr)
-	return err
+type withDisabledRelationsKey struct{}
+
+// WithDisabledRelations 返回的ctx用于List*EntityRelations时包含已禁用的relation，
+// 未通过query.Status指定状态时，默认只列出ENABLE的relation
+func WithDisabledRelations(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withDisabledRelationsKey{}, true)
+}
+
+func isWithDisabledRelations(ctx context.Context) bool {
+	with, _ := ctx.Value(withDisabledRelationsKey{}).(bool)
+	return with
 }
 
+// RelationList Relation以schema为key，RelationContent依次以schema、entity id为key，只包含未指定类型的relation
+//	TypedRelationContent依次以schema、entity id、relation类型为key，包含指定了类型的relation
 type RelationList struct {
-	Relation        map[string]interface{}      `json:"relation"`
-	RelationContent map[string]map[int64]string `json:"relation_content"`
+	Relation             map[string]interface{}                 `json:"relation"`
+	RelationContent      map[string]map[int64]string            `json:"relation_content"`
+	TypedRelationContent map[string]map[int64]map[string]string `json:"typed_relation_content,omitempty"`
 }
 
 // ListRelation 列出source_schema的relation
@@ -123,77 +195,154 @@ type RelationList struct {
 //		[ limit [pageSize] offset {pageSize*(page-1)} ]
 func (ma *MetaAgent) ListSourceEntityRelations(ctx context.Context, query *EntityRelation,
 	pageSize, page int, relationsInclude []string, filter map[string]map[string]string) (*RelationList, error) {
-	var err error
-	if err = checkListSourceEntityRelationsQuery(ctx, query, ma); err != nil {
+	if err := checkListSourceEntityRelationsQuery(ctx, query, ma); err != nil {
+		return nil, err
+	}
+	return ma.listRelatedEntities(ctx, query, true, pageSize, page, relationsInclude, filter)
+}
+
+// ListTargetEntityRelations 列出指向target_schema的relation，按source schema分组返回source entity
+//	relationsInclude、filter的key为source schema，RelationContent、TypedRelationContent以source entity id为key
+//	sql like:
+//	  query entity_relation
+//		select (column1, column2,...) from entity_relation
+//		where
+//		target_schema_name={target_schema_name} and target_entity_id={target_entity_id}
+//		[ and source_schema_name={source_schema_name}
+//			[and source_entity_id={source_entity_id} ] ]
+//
+//	  loop res.source_schemas query entity
+//		select (column1, column2,...) from {source_schema_name}
+//		where id in (?)
+//		[ and {filter} ]
+//		[ limit [pageSize] offset {pageSize*(page-1)} ]
+func (ma *MetaAgent) ListTargetEntityRelations(ctx context.Context, query *EntityRelation,
+	pageSize, page int, relationsInclude []string, filter map[string]map[string]string) (*RelationList, error) {
+	if err := checkListTargetEntityRelationsQuery(ctx, query, ma); err != nil {
 		return nil, err
 	}
+	return ma.listRelatedEntities(ctx, query, false, pageSize, page, relationsInclude, filter)
+}
+
+// listRelatedEntities 查询relation并按另一端的schema分组查询entity，
+// outgoing为true时另一端为target，否则为source
+func (ma *MetaAgent) listRelatedEntities(ctx context.Context, query *EntityRelation, outgoing bool,
+	pageSize, page int, relationsInclude []string, filter map[string]map[string]string) (*RelationList, error) {
+	var err error
 	// 检查relationsInclude是否有没注册的
+	for _, schemaName := range relationsInclude {
+		if _, exist := ma.pool[schemaName]; !exist {
+			return nil, &IdentifierError{Schema: schemaName}
+		}
+	}
 
-	// 查询EntityRelation
-	var total int
+	// 查询EntityRelation，默认排除已禁用的relation
+	if query.Status == "" && !isWithDisabledRelations(ctx) {
+		enabled := *query
+		enabled.Status = EntityRelationStatusENABLE
+		query = &enabled
+	}
 	var relationList []*EntityRelation
-	err, total = ma.QueryEntityListByStructCondition(ctx, &relationList, 0, 1, "id", true, query)
+	err, _ = ma.QueryEntityListByStructCondition(ctx, &relationList, 0, 1, "id", true, query)
 	if err != nil {
 		return nil, err
 	}
-	if total == 0 {
+	if len(relationList) == 0 {
 		return nil, nil
 	}
 
-	var targetIds = make(map[string][]int64)
+	var relatedIds = make(map[string][]int64)
 	var relationContent = make(map[string]map[int64]string)
+	var typedRelationContent = make(map[string]map[int64]map[string]string)
 	var res RelationList
-	targetSchemas := make([]string, 0)
+	relatedSchemas := make([]string, 0)
 	for _, r := range relationList {
-		targetSchemas = append(targetSchemas, r.TargetSchemaName)
-		targetIds[r.TargetSchemaName] = append(targetIds[r.TargetSchemaName], r.TargetEntityID)
-		_, exist := relationContent[r.TargetSchemaName]
-		if !exist {
-			relationContent[r.TargetSchemaName] = map[int64]string{}
+		schemaName, id := r.TargetSchemaName, r.TargetEntityID
+		if !outgoing {
+			schemaName, id = r.SourceSchemaName, r.SourceEntityID
+		}
+		relatedSchemas = append(relatedSchemas, schemaName)
+		relatedIds[schemaName] = append(relatedIds[schemaName], id)
+		if _, exist := relationContent[schemaName]; !exist {
+			relationContent[schemaName] = map[int64]string{}
+		}
+		if r.RelationType == "" {
+			relationContent[schemaName][id] = r.Content
+			continue
+		}
+		if _, exist := typedRelationContent[schemaName]; !exist {
+			typedRelationContent[schemaName] = map[int64]map[string]string{}
 		}
-		relationContent[r.TargetSchemaName][r.TargetEntityID] = r.Content
+		if _, exist := typedRelationContent[schemaName][id]; !exist {
+			typedRelationContent[schemaName][id] = map[string]string{}
+		}
+		typedRelationContent[schemaName][id][r.RelationType] = r.Content
 	}
 	res.RelationContent = relationContent
+	if len(typedRelationContent) > 0 {
+		res.TypedRelationContent = typedRelationContent
+	}
 	// 如果不指定包含的relation，将返回已有的所有relation
 	if len(relationsInclude) == 0 {
-		relationsInclude = targetSchemas
+		relationsInclude = relatedSchemas
 	}
 
 	var args []interface{}
 	var relations = make(map[string]interface{})
-	for _, targetSchema := range relationsInclude {
+	for _, relatedSchema := range relationsInclude {
 		// 如果还没有relation则跳过
-		ids := targetIds[targetSchema]
+		ids := relatedIds[relatedSchema]
 		if len(ids) == 0 {
 			continue
 		}
+		// 同一schema已查询过
+		if _, exist := relations[relatedSchema]; exist {
+			continue
+		}
+
+		entityListPtr, exist := ma.GetModelListPtr(relatedSchema)
+		if !exist {
+			return nil, &IdentifierError{Schema: relatedSchema}
+		}
 
 		args = args[:0]
-		// 构建query参数
+		// 构建query参数，filter的字段必须是该schema的列
+		scope := ma.GetReadDB(ctx).NewScope(newListElem(entityListPtr))
 		cond := "id in (?)"
 		args = append(args, cond)
 		args = append(args, ids)
-		for field, value := range filter[targetSchema] {
-			cond = cond + " and " + field + "=?"
+		for field, value := range filter[relatedSchema] {
+			column, err := quotedColumn(scope, field)
+			if err != nil {
+				return nil, err
+			}
+			cond = cond + " and " + column + "=?"
 			args = append(args, value)
 		}
 		args[0] = cond
 
 		// 查询
-		entityListPtr, _ := ma.GetModelListPtr(targetSchema)
-		err, _ = ma.QueryEntityListByStringCondition(ctx, entityListPtr, pageSize, page, "id", false, args...)
+		err, _ = ma.QueryEntityListByStringCondition(WithoutTotal(ctx), entityListPtr, pageSize, page, "id", false, args...)
 		if err != nil {
 			return nil, err
 		}
-		relations[targetSchema] = entityListPtr
+		relations[relatedSchema] = entityListPtr
 	}
 	res.Relation = relations
 	return &res, nil
 }
 
+// QueryRelationByUuid 通过唯一索引uuid的列查询relation，relation_type为空串时同样作为条件
+//	sql like:
+//		select (column1, column2,...) from entity_relation
+//		where source_schema_name={source_schema_name} and source_entity_id={source_entity_id}
+//		and target_schema_name={target_schema_name} and target_entity_id={target_entity_id}
+//		and relation_type={relation_type}
 func (ma *MetaAgent) QueryRelationByUuid(ctx context.Context, filter *EntityRelation) (*EntityRelation, error) {
 	var relation []*EntityRelation
-	err, _ := ma.QueryEntityListByStructCondition(ctx, &relation, 0, 1, "", false, filter)
+	cond := strings.Join(relationUuidColumns, "=? and ") + "=?"
+	err, _ := ma.QueryEntityListByStringCondition(WithoutTotal(ctx), &relation, 0, 1, "", false, cond,
+		filter.SourceSchemaName, filter.SourceEntityID, filter.TargetSchemaName, filter.TargetEntityID, filter.RelationType)
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +357,7 @@ func (ma *MetaAgent) UpdateRelationContentByID(ctx context.Context, relation *En
 	content := relation.Content
 	if relation.ID == 0 {
 		relation.Content = ""
-		relation, err = ma.QueryRelationByUuid(ctx, relation)
+		relation, err = ma.QueryRelationByUuid(WithPrimary(ctx), relation)
 		if err != nil {
 			return err
 		}
@@ -216,11 +365,41 @@ func (ma *MetaAgent) UpdateRelationContentByID(ctx context.Context, relation *En
 	return ma.UpdateEntitySingleColumnByID(ctx, relation, "content", content)
 }
 
+// SetRelationStatus 更新relation状态，relation的ID为空时通过uuid查找
+//	sql like:
+//		update entity_relation
+//		set status={status}
+//		where id={id}
+func (ma *MetaAgent) SetRelationStatus(ctx context.Context, relation *EntityRelation, status string) (err error) {
+	if status != EntityRelationStatusENABLE && status != EntityRelationStatusDISABLE {
+		return errors.New("invalid relation status: " + status)
+	}
+	if relation.ID == 0 {
+		relation.Content = ""
+		relation.Status = ""
+		relation, err = ma.QueryRelationByUuid(WithPrimary(ctx), relation)
+		if err != nil {
+			return err
+		}
+	}
+	return ma.UpdateEntitySingleColumnByID(ctx, relation, "status", status)
+}
+
+// EnableRelation 启用relation
+func (ma *MetaAgent) EnableRelation(ctx context.Context, relation *EntityRelation) error {
+	return ma.SetRelationStatus(ctx, relation, EntityRelationStatusENABLE)
+}
+
+// DisableRelation 禁用relation，禁用后默认不会被List*EntityRelations列出
+func (ma *MetaAgent) DisableRelation(ctx context.Context, relation *EntityRelation) error {
+	return ma.SetRelationStatus(ctx, relation, EntityRelationStatusDISABLE)
+}
+
 // 删除relation
 func (ma *MetaAgent) DeleteRelation(ctx context.Context, relation *EntityRelation) (err error) {
 	if relation.ID == 0 {
 		relation.Content = ""
-		relation, err = ma.QueryRelationByUuid(ctx, relation)
+		relation, err = ma.QueryRelationByUuid(WithPrimary(ctx), relation)
 		if err != nil {
 			return err
 		}
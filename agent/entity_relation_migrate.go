@@ -0,0 +1,83 @@
+package agent
+
+// entity_relation表结构迁移
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// relationUuidIndex EntityRelation的唯一索引名
+const relationUuidIndex = "uuid"
+
+// MigrateEntityRelation 迁移entity_relation表结构，Init时自动执行
+//	AutoMigrate只补充缺少的列和索引，不会修改已存在的索引，
+//	旧版本的uuid索引不包含relation_type时删除后按relationUuidColumns重建
+//	sql like:
+//		drop index uuid [on entity_relation]
+//		[alter table entity_relation modify column relation_type varchar(64) not null default '']
+//		create unique index uuid on entity_relation
+//		(source_schema_name, source_entity_id, target_schema_name, target_entity_id, relation_type)
+func MigrateEntityRelation(db *gorm.DB) error {
+	if err := db.AutoMigrate(new(EntityRelation)).Error; err != nil {
+		return err
+	}
+	table := db.NewScope(new(EntityRelation)).TableName()
+	columns, err := indexColumns(db, table, relationUuidIndex)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column == "relation_type" {
+			return nil
+		}
+	}
+	model := db.Model(new(EntityRelation))
+	if len(columns) > 0 {
+		if err = model.RemoveIndex(relationUuidIndex).Error; err != nil {
+			return err
+		}
+	}
+	// 早期版本创建的relation_type为varchar(255)，mysql utf8mb4下索引会超长，按当前定义修改列类型
+	if db.Dialect().GetName() == "mysql" {
+		field, _ := db.NewScope(new(EntityRelation)).FieldByName("relation_type")
+		if err = model.ModifyColumn(field.DBName, db.Dialect().DataTypeOf(field.StructField)).Error; err != nil {
+			return err
+		}
+	}
+	return model.AddUniqueIndex(relationUuidIndex, relationUuidColumns...).Error
+}
+
+// indexColumns 返回表上索引包含的列，索引不存在时返回空
+func indexColumns(db *gorm.DB, table, index string) ([]string, error) {
+	var query string
+	var args []interface{}
+	switch db.Dialect().GetName() {
+	case "mysql":
+		query = "SELECT column_name FROM information_schema.statistics " +
+			"WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ? ORDER BY seq_in_index"
+		args = []interface{}{table, index}
+	case "postgres":
+		query = "SELECT a.attname FROM pg_index i " +
+			"JOIN pg_class c ON c.oid = i.indexrelid " +
+			"JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey) " +
+			"WHERE c.relname = ? AND i.indrelid = CAST(? AS regclass)"
+		args = []interface{}{index, table}
+	default:
+		query = "SELECT name FROM pragma_index_info(?) ORDER BY seqno"
+		args = []interface{}{index}
+	}
+	rows, err := db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"github.com/jinzhu/gorm"
+	"github.com/lucky-loki/orm"
+	"strings"
+	"testing"
+)
+
+// legacyEntityRelation 加入relation_type之前的EntityRelation表结构
+type legacyEntityRelation struct {
+	Entity
+
+	SourceSchemaName string `gorm:"unique_index:uuid"`
+	SourceEntityID   int64  `gorm:"unique_index:uuid"`
+	TargetSchemaName string `gorm:"unique_index:uuid"`
+	TargetEntityID   int64  `gorm:"unique_index:uuid"`
+	Content          string `gorm:"type:blob"`
+}
+
+func (r *legacyEntityRelation) TableName() string {
+	return "entity_relation"
+}
+
+func TestMigrateEntityRelation(t *testing.T) {
+	c := &orm.Config{Database: t.Name()}
+	db, err := c.OpenSqlite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.AutoMigrate(new(legacyEntityRelation), new(testUser)).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := []*testUser{{Name: "u1"}, {Name: "u2"}}
+	for _, u := range users {
+		if err = db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy := &legacyEntityRelation{
+		SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+		TargetSchemaName: "test_user", TargetEntityID: users[1].ID,
+	}
+	if err = db.Create(legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 重复执行不报错
+	for i := 0; i < 2; i++ {
+		if err = MigrateEntityRelation(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	columns, err := indexColumns(db, "entity_relation", relationUuidIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != len(relationUuidColumns) {
+		t.Errorf("uuid index columns got %v", columns)
+	}
+
+	ma := NewMetaAgent(db)
+	ma.RegisterSchema(new(EntityRelation))
+	ma.RegisterSchema(new(testUser))
+	ctx := context.Background()
+	relation := func(relationType string) *EntityRelation {
+		return &EntityRelation{
+			SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+			TargetSchemaName: "test_user", TargetEntityID: users[1].ID,
+			RelationType: relationType,
+		}
+	}
+	if err = ma.CreateRelation(ctx, relation("follow")); err != nil {
+		t.Fatalf("create typed relation after migrate failed: %s", err)
+	}
+	r := relation("")
+	r.Content = "upsert"
+	if err = ma.UpsertRelation(ctx, r); err != nil {
+		t.Fatalf("upsert after migrate failed: %s", err)
+	}
+	got, err := ma.QueryRelationByUuid(ctx, relation(""))
+	if err != nil || got.ID != legacy.ID || got.Content != "upsert" || got.Status != EntityRelationStatusENABLE {
+		t.Errorf("legacy relation got %+v, %v", got, err)
+	}
+}
+
+func TestEntityRelation_RelationTypeSize(t *testing.T) {
+	ma := newTestAgent(t)
+	field, ok := ma.db.NewScope(new(EntityRelation)).FieldByName("relation_type")
+	if !ok {
+		t.Fatal("relation_type not found")
+	}
+	// utf8mb4下uuid索引: 2个varchar(255) + varchar(64) + 2个bigint = 2*1020 + 256 + 16 <= 3072
+	mysql, _ := gorm.GetDialect("mysql")
+	if got := mysql.DataTypeOf(field.StructField); !strings.HasPrefix(got, "varchar(64)") {
+		t.Errorf("relation_type column type got %s", got)
+	}
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetaAgent_ListTargetEntityRelations(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	users := []*testUser{{Name: "u1"}, {Name: "u2"}, {Name: "u3"}}
+	for _, u := range users {
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := &testDoc{Title: "d1"}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*EntityRelation{
+		{SourceSchemaName: "test_user", SourceEntityID: users[0].ID, Content: "c1"},
+		{SourceSchemaName: "test_user", SourceEntityID: users[1].ID, Content: "c2"},
+		{SourceSchemaName: "test_user", SourceEntityID: users[1].ID, RelationType: "follow", Content: "c2f"},
+		{SourceSchemaName: "test_doc", SourceEntityID: doc.ID, Content: "c3"},
+	} {
+		r.TargetSchemaName, r.TargetEntityID = "test_user", users[2].ID
+		if err := ma.CreateRelation(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := &EntityRelation{TargetSchemaName: "test_user", TargetEntityID: users[2].ID}
+	res, err := ma.ListTargetEntityRelations(ctx, query, 0, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *res.Relation["test_user"].(*[]*testUser); fmt.Sprint(userNames(got)) != "[u1 u2]" {
+		t.Errorf("got users %v", userNames(got))
+	}
+	if got := *res.Relation["test_doc"].(*[]*testDoc); len(got) != 1 || got[0].Title != "d1" {
+		t.Errorf("got docs %+v", got)
+	}
+	if res.RelationContent["test_user"][users[1].ID] != "c2" || res.RelationContent["test_doc"][doc.ID] != "c3" {
+		t.Errorf("got content %v", res.RelationContent)
+	}
+	// 同一对entity不同类型的relation content互不覆盖
+	if res.TypedRelationContent["test_user"][users[1].ID]["follow"] != "c2f" || len(res.TypedRelationContent["test_doc"]) != 0 {
+		t.Errorf("got typed content %v", res.TypedRelationContent)
+	}
+
+	// 限定source schema并过滤
+	query.SourceSchemaName = "test_user"
+	res, err = ma.ListTargetEntityRelations(ctx, query, 0, 1, nil,
+		map[string]map[string]string{"test_user": {"name": "u2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Relation) != 1 || fmt.Sprint(userNames(*res.Relation["test_user"].(*[]*testUser))) != "[u2]" {
+		t.Errorf("got %v", res.Relation)
+	}
+
+	// 没有指向它的relation
+	res, err = ma.ListTargetEntityRelations(ctx, &EntityRelation{TargetSchemaName: "test_user", TargetEntityID: users[0].ID}, 0, 1, nil, nil)
+	if err != nil || res != nil {
+		t.Errorf("got %v, %v", res, err)
+	}
+	for _, q := range []*EntityRelation{
+		{TargetSchemaName: "test_user"},
+		{TargetSchemaName: "not_exist", TargetEntityID: 1},
+		{TargetSchemaName: "test_user", TargetEntityID: 100},
+		{TargetSchemaName: "test_user", TargetEntityID: users[2].ID, SourceSchemaName: "not_exist"},
+	} {
+		if _, err = ma.ListTargetEntityRelations(ctx, q, 0, 1, nil, nil); err == nil {
+			t.Errorf("query %+v should fail", q)
+		}
+	}
+
+	// http
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	w := httptest.NewRecorder()
+	path := fmt.Sprintf("/entity/test_user/by/id/%d/incoming?relations=test_doc", users[2].ID)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var resp struct {
+		Data struct {
+			Relation map[string][]testDoc `json:"relation"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Data.Relation) != 1 || len(resp.Data.Relation["test_doc"]) != 1 {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestMetaAgent_RelationStatus(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	users := []*testUser{{Name: "u1"}, {Name: "u2"}, {Name: "u3"}}
+	for _, u := range users {
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 两端必须存在，status必须合法
+	for _, r := range []*EntityRelation{
+		{SourceSchemaName: "test_user", SourceEntityID: users[0].ID, TargetSchemaName: "test_user", TargetEntityID: 100},
+		{SourceSchemaName: "not_exist", SourceEntityID: 1, TargetSchemaName: "test_user", TargetEntityID: users[1].ID},
+		{SourceSchemaName: "test_user", SourceEntityID: users[0].ID, TargetSchemaName: "test_user"},
+		{SourceSchemaName: "test_user", SourceEntityID: users[0].ID, TargetSchemaName: "test_user",
+			TargetEntityID: users[1].ID, Status: "UNKNOWN"},
+	} {
+		if err := ma.CreateRelation(ctx, r); err == nil {
+			t.Errorf("relation %+v should fail", r)
+		}
+	}
+
+	var relations []*EntityRelation
+	for _, u := range users[1:] {
+		r := &EntityRelation{
+			SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+			TargetSchemaName: "test_user", TargetEntityID: u.ID,
+		}
+		if err := ma.CreateRelation(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != EntityRelationStatusENABLE {
+			t.Errorf("got status %s", r.Status)
+		}
+		relations = append(relations, r)
+	}
+
+	// 通过uuid禁用
+	if err := ma.DisableRelation(ctx, &EntityRelation{
+		SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+		TargetSchemaName: "test_user", TargetEntityID: users[2].ID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	list := func(ctx context.Context, status string) []string {
+		query := &EntityRelation{SourceSchemaName: "test_user", SourceEntityID: users[0].ID, Status: status}
+		res, err := ma.ListSourceEntityRelations(ctx, query, 0, 1, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res == nil {
+			return nil
+		}
+		return userNames(*res.Relation["test_user"].(*[]*testUser))
+	}
+	if got := fmt.Sprint(list(ctx, "")); got != "[u2]" {
+		t.Errorf("enabled got %s", got)
+	}
+	if got := fmt.Sprint(list(ctx, EntityRelationStatusDISABLE)); got != "[u3]" {
+		t.Errorf("disabled got %s", got)
+	}
+	if got := fmt.Sprint(list(WithDisabledRelations(ctx), "")); got != "[u2 u3]" {
+		t.Errorf("all got %s", got)
+	}
+
+	if err := ma.EnableRelation(ctx, relations[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(list(ctx, "")); got != "[u2 u3]" {
+		t.Errorf("after enable got %s", got)
+	}
+	if err := ma.SetRelationStatus(ctx, relations[0], "UNKNOWN"); err == nil {
+		t.Error("invalid status should fail")
+	}
+}
@@ -1,7 +1,70 @@
 package agent
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"testing"
+)
 
 func TestCreateModel(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
 
+	u := &testUser{Name: "loki", Age: 18}
+	if err := ma.CreateEntity(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == 0 {
+		t.Fatal("id not generated")
+	}
+
+	var got testUser
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != u.Name || got.Age != u.Age {
+		t.Errorf("got %+v, want %+v", got, *u)
+	}
+}
+
+func TestQueryEntityListByStringCondition(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i), Age: i % 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 统计查询数据的次数，count不经过query回调
+	finds := 0
+	ma.db.Callback().Query().After("gorm:query").Register("test:count_find", func(*gorm.Scope) {
+		finds++
+	})
+
+	for _, c := range []struct {
+		ctx      context.Context
+		pageSize int
+		page     int
+		total    int
+		names    string
+		finds    int
+	}{
+		{ctx, 2, 1, 3, "[u5 u3]", 1},
+		{ctx, 2, 2, 3, "[u1]", 1},
+		{ctx, 2, 3, 3, "[]", 0},
+		{ctx, 0, 0, 3, "[u5 u3 u1]", 1},
+		{WithoutTotal(ctx), 2, 2, -1, "[u1]", 1},
+		{WithoutTotal(ctx), 0, 0, -1, "[u5 u3 u1]", 1},
+	} {
+		finds = 0
+		list := []*testUser{{Name: "stale"}}
+		err, total := ma.QueryEntityListByStringCondition(c.ctx, &list, c.pageSize, c.page, "id", true, "age=?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != c.total || fmt.Sprint(userNames(list)) != c.names || finds != c.finds {
+			t.Errorf("page %d/%d got total %d, %v, %d finds", c.page, c.pageSize, total, userNames(list), finds)
+		}
+	}
 }
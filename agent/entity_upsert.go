@@ -0,0 +1,167 @@
+package agent
+
+// 插入或在唯一键冲突时更新
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// UpsertOptions upsert选项，列名均为数据库列名
+type UpsertOptions struct {
+	// 判断冲突的唯一索引列，postgres与sqlite必填；mysql按表上所有唯一索引判断冲突，该值只用于校验
+	ConflictColumns []string
+	// 冲突时更新的列，为空时更新除主键、created_at、version、冲突列之外的所有列
+	UpdateColumns []string
+}
+
+// UpsertEntity 插入数据，如果唯一索引冲突则更新已有数据，执行后mPtr的ID为插入或更新的记录ID
+//	如果model实现了Versioner，同CreateEntity插入时version为0，冲突更新时version加1，
+//	不会用mPtr的version覆盖已有数据，mPtr的version不会被更新
+//	sql like:
+//	  mysql
+//		insert into {schema_name} (column1, column2, ...)
+//		values ({value1}, {value2}, ...)
+//		on duplicate key update id=last_insert_id(id), column1=values(column1), ...
+//	  postgres, sqlite
+//		insert into {schema_name} (column1, column2, ...)
+//		values ({value1}, {value2}, ...)
+//		on conflict (conflict_column1, ...) do update set column1=excluded.column1, ...
+//	EntityRelation需使用UpsertRelation，否则返回ErrRelationBypass
+func (ma *MetaAgent) UpsertEntity(ctx context.Context, mPtr interface{}, opts *UpsertOptions) error {
+	if _, ok := mPtr.(*EntityRelation); ok {
+		return ErrRelationBypass
+	}
+	return ma.upsertEntity(ctx, mPtr, opts)
+}
+
+// upsertEntity 同UpsertEntity，不拒绝EntityRelation，供UpsertRelation检查relation类型后调用
+func (ma *MetaAgent) upsertEntity(ctx context.Context, mPtr interface{}, opts *UpsertOptions) error {
+	var err error
+	if err = ma.checkEntity(ctx, mPtr); err != nil {
+		return err
+	}
+	if s, ok := mPtr.(Schema); ok {
+		s.SetID(0)
+	}
+	if v, ok := mPtr.(Versioner); ok {
+		v.SetVersion(0)
+	}
+	if opts == nil {
+		opts = &UpsertOptions{}
+	}
+	db := ma.GetDB(ctx)
+	dialect := db.Dialect().GetName()
+	scope := db.NewScope(mPtr)
+	clause, err := upsertClause(scope, dialect, opts)
+	if err != nil {
+		return err
+	}
+	if err = db.Set("gorm:insert_option", clause).Create(mPtr).Error; err != nil {
+		return err
+	}
+
+	// sqlite更新已有数据时last_insert_rowid不会变化，需要按冲突列查出ID
+	if dialect == "sqlite3" {
+		query := db.Table(scope.TableName())
+		for _, column := range opts.ConflictColumns {
+			field, _ := scope.FieldByName(column)
+			query = query.Where(scope.Quote(field.DBName)+" = ?", field.Field.Interface())
+		}
+		var id int64
+		if err = query.Select(scope.Quote(scope.PrimaryKey())).Row().Scan(&id); err != nil {
+			return err
+		}
+		return scope.SetColumn(scope.PrimaryKey(), id)
+	}
+	return nil
+}
+
+// upsertClause 生成各方言的冲突处理子句，并校验列名
+func upsertClause(scope *gorm.Scope, dialect string, opts *UpsertOptions) (string, error) {
+	if dialect != "mysql" && len(opts.ConflictColumns) == 0 {
+		return "", errors.New("upsert conflict columns can not be empty")
+	}
+	conflicts, err := upsertColumns(scope, opts.ConflictColumns)
+	if err != nil {
+		return "", err
+	}
+	conflict := make(map[string]bool, len(conflicts))
+	for _, column := range conflicts {
+		conflict[column] = true
+	}
+
+	updates, err := upsertColumns(scope, opts.UpdateColumns)
+	if err != nil {
+		return "", err
+	}
+	if len(updates) == 0 {
+		for _, field := range scope.Fields() {
+			if !field.IsNormal || field.IsIgnored || field.IsPrimaryKey ||
+				field.DBName == "created_at" || conflict[field.DBName] {
+				continue
+			}
+			updates = append(updates, field.DBName)
+		}
+	}
+	// version由数据库加1，不能被插入值覆盖
+	_, versioned := scope.Value.(Versioner)
+	if versioned {
+		kept := updates[:0:0]
+		for _, column := range updates {
+			if column != "version" {
+				kept = append(kept, column)
+			}
+		}
+		updates = kept
+	}
+	// 没有可更新的列时用冲突列自我赋值，保证postgres的returning能返回已有数据的ID
+	if len(updates) == 0 {
+		updates = conflicts
+	}
+
+	sets := make([]string, 0, len(updates)+1)
+	if dialect == "mysql" {
+		// 让last_insert_id返回被更新数据的ID
+		pk := scope.Quote(scope.PrimaryKey())
+		sets = append(sets, fmt.Sprintf("%s=LAST_INSERT_ID(%s)", pk, pk))
+	}
+	for _, column := range updates {
+		quoted := scope.Quote(column)
+		if dialect == "mysql" {
+			sets = append(sets, fmt.Sprintf("%s=VALUES(%s)", quoted, quoted))
+		} else {
+			sets = append(sets, fmt.Sprintf("%s=EXCLUDED.%s", quoted, quoted))
+		}
+	}
+	if versioned {
+		version := scope.Quote("version")
+		sets = append(sets, fmt.Sprintf("%s=%s.%s+1", version, scope.QuotedTableName(), version))
+	}
+
+	if dialect == "mysql" {
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", "), nil
+	}
+	quotedConflict := make([]string, len(conflicts))
+	for i, column := range conflicts {
+		quotedConflict[i] = scope.Quote(column)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s",
+		strings.Join(quotedConflict, ", "), strings.Join(sets, ", ")), nil
+}
+
+// upsertColumns 校验列名是否属于model，并统一转换为数据库列名
+func upsertColumns(scope *gorm.Scope, columns []string) ([]string, error) {
+	dbNames := make([]string, len(columns))
+	for i, column := range columns {
+		dbName, err := columnName(scope, column)
+		if err != nil {
+			return nil, err
+		}
+		dbNames[i] = dbName
+	}
+	return dbNames, nil
+}
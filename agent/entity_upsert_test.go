@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestUpsertClause(t *testing.T) {
+	ma := newTestAgent(t)
+	scope := ma.db.NewScope(&EntityRelation{})
+	opts := &UpsertOptions{ConflictColumns: relationUuidColumns, UpdateColumns: []string{"content"}}
+
+	cases := map[string]string{
+		"mysql": "ON DUPLICATE KEY UPDATE \"id\"=LAST_INSERT_ID(\"id\"), \"content\"=VALUES(\"content\")",
+		"postgres": "ON CONFLICT (\"source_schema_name\", \"source_entity_id\", \"target_schema_name\", \"target_entity_id\", \"relation_type\")" +
+			" DO UPDATE SET \"content\"=EXCLUDED.\"content\"",
+	}
+	for dialect, want := range cases {
+		got, err := upsertClause(scope, dialect, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s got %s, want %s", dialect, got, want)
+		}
+	}
+
+	if _, err := upsertClause(scope, "postgres", &UpsertOptions{}); err == nil {
+		t.Error("empty conflict columns should fail on postgres")
+	}
+	opts = &UpsertOptions{ConflictColumns: []string{"id;drop table entity_relation"}}
+	if _, err := upsertClause(scope, "postgres", opts); err == nil {
+		t.Error("unknown column should fail")
+	}
+}
+
+func TestMetaAgent_UpsertRelation(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	// relation的两端必须存在
+	for i := 1; i <= 3; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newRelation := func(content string) *EntityRelation {
+		return &EntityRelation{
+			SourceSchemaName: "test_user",
+			SourceEntityID:   1,
+			TargetSchemaName: "test_user",
+			TargetEntityID:   2,
+			Content:          content,
+		}
+	}
+	other := newRelation("other")
+	other.TargetEntityID = 3
+	if err := ma.CreateRelation(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	first := newRelation("v1")
+	if err := ma.UpsertRelation(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := newRelation("v2")
+	if err := ma.UpsertRelation(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || second.ID != first.ID {
+		t.Errorf("upsert should hit the same relation, got ids %d and %d", first.ID, second.ID)
+	}
+
+	var relations []*EntityRelation
+	err, total := ma.QueryEntityListByStringCondition(ctx, &relations, 0, 0, "", false)
+	if err != nil || total != 2 {
+		t.Fatalf("got %d relations, err %v", total, err)
+	}
+	got, err := ma.QueryRelationByUuid(ctx, newRelation(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != "v2" {
+		t.Errorf("content should be updated, got %s", got.Content)
+	}
+}
+
+type testPage struct {
+	VersionedEntity
+
+	Slug  string `json:"slug" gorm:"unique_index"`
+	Title string `json:"title"`
+}
+
+func (p *testPage) SchemaName() string {
+	return "test_page"
+}
+
+func (p *testPage) NewFunc() interface{} {
+	return &testPage{}
+}
+
+func (p *testPage) NewListFunc() interface{} {
+	var list []*testPage
+	return &list
+}
+
+func (p *testPage) GetID() int64 {
+	return p.ID
+}
+
+func (p *testPage) SetID(id int64) {
+	p.ID = id
+}
+
+func TestMetaAgent_UpsertEntity_Version(t *testing.T) {
+	ma := newTestAgent(t, new(testPage))
+	ctx := context.Background()
+	opts := &UpsertOptions{ConflictColumns: []string{"slug"}}
+
+	page := &testPage{Slug: "home", Title: "v0"}
+	page.Version = 5
+	if err := ma.UpsertEntity(ctx, page, opts); err != nil {
+		t.Fatal(err)
+	}
+	page.Title = "v1"
+	if err := ma.UpdateEntityByID(ctx, page); err != nil {
+		t.Fatal(err)
+	}
+
+	// 冲突更新时version加1，而不是被插入值覆盖
+	for _, title := range []string{"v2", "v3"} {
+		if err := ma.UpsertEntity(ctx, &testPage{Slug: "home", Title: title}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got testPage
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "slug=?", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "v3" || got.Version != 3 {
+		t.Errorf("got %+v", got)
+	}
+}
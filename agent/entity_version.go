@@ -0,0 +1,87 @@
+package agent
+
+// 基于version列的乐观锁
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+)
+
+var ErrVersionConflict = errors.New("version conflict, entity has been modified or deleted")
+
+// Versioner 实现了Versioner的model在UpdateEntityByID时进行乐观锁检查，
+//	其余更新方式不检查version，但同样将version加1
+type Versioner interface {
+	GetVersion() int64
+	SetVersion(int64)
+}
+
+var _ Versioner = &VersionedEntity{}
+
+// VersionedEntity 带version列的Entity，嵌入后替代Entity即可开启乐观锁
+type VersionedEntity struct {
+	Entity
+	Version int64 `json:"version" gorm:"not null"`
+}
+
+func (v *VersionedEntity) GetVersion() int64 {
+	return v.Version
+}
+
+func (v *VersionedEntity) SetVersion(version int64) {
+	v.Version = version
+}
+
+// updateVersionedEntity 全量更新并将version加1，version不匹配时返回ErrVersionConflict
+//	sql like:
+//		update {schema_name}
+//		set column1={value1}, ..., version={version+1}
+//		where id={id} and version={version}
+func updateVersionedEntity(db *gorm.DB, mPtr interface{}, v Versioner) error {
+	columns := map[string]interface{}{}
+	for _, field := range db.NewScope(mPtr).Fields() {
+		if !field.IsNormal || field.IsPrimaryKey {
+			continue
+		}
+		if field.Name == "CreatedAt" && field.IsBlank {
+			continue
+		}
+		columns[field.DBName] = field.Field.Interface()
+	}
+	return updateVersionedColumns(db, mPtr, v, columns)
+}
+
+// updateVersionedColumns 更新指定列并将version加1，version不匹配时返回ErrVersionConflict
+func updateVersionedColumns(db *gorm.DB, mPtr interface{}, v Versioner, columns map[string]interface{}) error {
+	if db.NewScope(mPtr).PrimaryKeyZero() {
+		return ErrZeroPrimaryKey
+	}
+	version := v.GetVersion()
+	columns["version"] = version + 1
+
+	res := db.Model(mPtr).Where("version = ?", version).Updates(columns)
+	if res.Error != nil {
+		v.SetVersion(version)
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		v.SetVersion(version)
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// bumpVersion model实现了Versioner且未显式更新version列时，在updates中追加version加1
+//	sql like:
+//		update {schema_name}
+//		set column1={value1}, ..., version=version+1
+//		where {where...}
+func bumpVersion(mPtr interface{}, updates map[string]interface{}) {
+	if _, ok := mPtr.(Versioner); !ok {
+		return
+	}
+	if _, ok := updates["version"]; ok {
+		return
+	}
+	updates["version"] = gorm.Expr("version + 1")
+}
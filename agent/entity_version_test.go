@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testDoc struct {
+	VersionedEntity
+
+	Title string `json:"title"`
+}
+
+func (d *testDoc) SchemaName() string {
+	return "test_doc"
+}
+
+func (d *testDoc) NewFunc() interface{} {
+	return &testDoc{}
+}
+
+func (d *testDoc) NewListFunc() interface{} {
+	var list []*testDoc
+	return &list
+}
+
+func (d *testDoc) GetID() int64 {
+	return d.ID
+}
+
+func (d *testDoc) SetID(id int64) {
+	d.ID = id
+}
+
+func TestMetaAgent_UpdateEntityByID_Version(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+
+	doc := &testDoc{Title: "v0"}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	stale := *doc
+
+	doc.Title = "v1"
+	if err := ma.UpdateEntityByID(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 1 {
+		t.Errorf("version should be increased, got %d", doc.Version)
+	}
+
+	stale.Title = "stale"
+	if err := ma.UpdateEntityByID(ctx, &stale); err != ErrVersionConflict {
+		t.Fatalf("stale update got err %v", err)
+	}
+	if stale.Version != 0 {
+		t.Errorf("version should be restored after conflict, got %d", stale.Version)
+	}
+
+	var got testDoc
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "v1" || got.Version != 1 {
+		t.Errorf("unexpected doc in db: %+v", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	w := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"title":"stale","version":0}`)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/entity/test_doc/by/id/1", body))
+	if w.Code != http.StatusConflict {
+		t.Errorf("stale PUT got status %d", w.Code)
+	}
+}
+
+func TestMetaAgent_UpdateColumns_BumpVersion(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+
+	doc := &testDoc{Title: "v0"}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.UpdateEntitySingleColumnByID(ctx, doc, "title", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.UpdateEntitySingleColumnByStringCondition(ctx, "test_doc", "title", "v2", "id=?", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	q := ma.NewQuery("test_doc").Where(Eq("id", doc.ID))
+	if err := ma.UpdateEntityColumnsByQuery(ctx, q, map[string]interface{}{"title": "v3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testDoc
+	if err := ma.QueryOneEntityByStringFilter(ctx, &got, "id=?", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "v3" || got.Version != 3 {
+		t.Errorf("each update should bump version, got %+v", got)
+	}
+}
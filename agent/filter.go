@@ -0,0 +1,416 @@
+package agent
+
+// REST列表接口的过滤表达式与排序参数解析
+//	filter: age>=18 and (status in ("A","B") or name like "lo%") and deleted_at is null
+//	sort:   -created_at,name
+
+import (
+	"fmt"
+	"github.com/lucky-loki/orm/agent/utils"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FilterError 过滤表达式或排序参数不合法
+type FilterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter error at %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize 将表达式拆分为token
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(rs) && rs[i] != r; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				sb.WriteRune(rs[i])
+			}
+			if i >= len(rs) {
+				return nil, &FilterError{start, "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{tokenString, sb.String(), start})
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			for i < len(rs) && strings.ContainsRune("=!<>", rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenOp, string(rs[start:i]), start})
+		case unicode.IsDigit(r) || r == '-' || r == '+':
+			start := i
+			for i++; i < len(rs) && (unicode.IsDigit(rs[i]) || strings.ContainsRune(".eE+-", rs[i])); i++ {
+			}
+			tokens = append(tokens, token{tokenNumber, string(rs[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(rs[start:i]), start})
+		default:
+			return nil, &FilterError{i, fmt.Sprintf("unexpected character '%c'", r)}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(rs)}), nil
+}
+
+// filterParser 递归下降解析
+//	expr       := and ("or" and)*
+//	and        := primary ("and" primary)*
+//	primary    := "(" expr ")" | comparison
+//	comparison := field op value
+//	            | field ["not"] "in" "(" value ("," value)* ")"
+//	            | field "is" ["not"] "null"
+//	            | field "like" string
+//	            | field "between" value "and" value
+type filterParser struct {
+	model  interface{}
+	tokens []token
+	pos    int
+}
+
+// ParseFilter 解析过滤表达式为查询条件，字段名为model的json tag名
+func ParseFilter(model interface{}, expr string) (Cond, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return Cond{}, err
+	}
+	p := &filterParser{model: model, tokens: tokens}
+	cond, err := p.parseOr()
+	if err != nil {
+		return Cond{}, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return Cond{}, &FilterError{t.pos, fmt.Sprintf("unexpected '%s'", t.text)}
+	}
+	return cond, nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword 如果下一个token是关键字kw则消费它
+func (p *filterParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind tokenKind, desc string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, &FilterError{t.pos, fmt.Sprintf("expect %s, got '%s'", desc, t.text)}
+	}
+	return t, nil
+}
+
+func (p *filterParser) parseOr() (Cond, error) {
+	cond, err := p.parseAnd()
+	if err != nil {
+		return cond, err
+	}
+	conds := []Cond{cond}
+	for p.keyword("or") {
+		if cond, err = p.parseAnd(); err != nil {
+			return cond, err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return Or(conds...), nil
+}
+
+func (p *filterParser) parseAnd() (Cond, error) {
+	cond, err := p.parsePrimary()
+	if err != nil {
+		return cond, err
+	}
+	conds := []Cond{cond}
+	for p.keyword("and") {
+		if cond, err = p.parsePrimary(); err != nil {
+			return cond, err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return And(conds...), nil
+}
+
+func (p *filterParser) parsePrimary() (Cond, error) {
+	if p.peek().kind == tokenLParen {
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return cond, err
+		}
+		_, err = p.expect(tokenRParen, "')'")
+		return cond, err
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Cond, error) {
+	t, err := p.expect(tokenIdent, "field")
+	if err != nil {
+		return Cond{}, err
+	}
+	column, err := filterColumn(p.model, t)
+	if err != nil {
+		return Cond{}, err
+	}
+
+	switch {
+	case p.keyword("in"):
+		values, err := p.parseValueList()
+		return In(column, values...), err
+	case p.keyword("not"):
+		if !p.keyword("in") {
+			t := p.peek()
+			return Cond{}, &FilterError{t.pos, "expect 'in' after 'not'"}
+		}
+		values, err := p.parseValueList()
+		return NotIn(column, values...), err
+	case p.keyword("is"):
+		not := p.keyword("not")
+		if !p.keyword("null") {
+			t := p.peek()
+			return Cond{}, &FilterError{t.pos, "expect 'null' after 'is'"}
+		}
+		if not {
+			return NotNull(column), nil
+		}
+		return IsNull(column), nil
+	case p.keyword("like"):
+		t, err := p.expect(tokenString, "string")
+		return Like(column, t.text), err
+	case p.keyword("between"):
+		from, err := p.parseValue()
+		if err != nil {
+			return Cond{}, err
+		}
+		if !p.keyword("and") {
+			t := p.peek()
+			return Cond{}, &FilterError{t.pos, "expect 'and' in between"}
+		}
+		to, err := p.parseValue()
+		return Between(column, from, to), err
+	}
+
+	op := p.next()
+	if op.kind != tokenOp {
+		return Cond{}, &FilterError{op.pos, fmt.Sprintf("unknown operator '%s'", op.text)}
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return Cond{}, err
+	}
+	switch op.text {
+	case "=", "==":
+		return Eq(column, value), nil
+	case "!=", "<>":
+		return Ne(column, value), nil
+	case "<":
+		return Lt(column, value), nil
+	case "<=":
+		return Lte(column, value), nil
+	case ">":
+		return Gt(column, value), nil
+	case ">=":
+		return Gte(column, value), nil
+	}
+	return Cond{}, &FilterError{op.pos, fmt.Sprintf("unknown operator '%s'", op.text)}
+}
+
+func (p *filterParser) parseValueList() ([]interface{}, error) {
+	if _, err := p.expect(tokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		}
+		if t.kind != tokenComma {
+			return nil, &FilterError{t.pos, fmt.Sprintf("expect ',' or ')', got '%s'", t.text)}
+		}
+	}
+}
+
+func (p *filterParser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return f, nil
+		}
+		return nil, &FilterError{t.pos, fmt.Sprintf("invalid number '%s'", t.text)}
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return nil, &FilterError{t.pos, fmt.Sprintf("expect value, got '%s'", t.text)}
+}
+
+// filterColumn 将json tag名映射为结构体字段名
+func filterColumn(model interface{}, t token) (string, error) {
+	sf, ok := utils.FieldByTag(model, t.text, "json")
+	if !ok {
+		return "", &FilterError{t.pos, fmt.Sprintf("unknown field '%s'", t.text)}
+	}
+	return sf.Name, nil
+}
+
+// SortField 排序字段，Column为结构体字段名
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSort 解析排序参数，逗号分隔，字段名为model的json tag名，前缀-表示倒序
+//	-created_at,name => created_at desc, name asc
+func ParseSort(model interface{}, sort string) ([]SortField, error) {
+	var fields []SortField
+	pos := 0
+	for _, item := range strings.Split(sort, ",") {
+		t := token{kind: tokenIdent, text: strings.TrimSpace(item), pos: pos}
+		pos += len(item) + 1
+		var f SortField
+		if strings.HasPrefix(t.text, "-") {
+			f.Desc = true
+			t.text = t.text[1:]
+		} else {
+			t.text = strings.TrimPrefix(t.text, "+")
+		}
+		if t.text == "" {
+			return nil, &FilterError{t.pos, "empty sort field"}
+		}
+		column, err := filterColumn(model, t)
+		if err != nil {
+			return nil, err
+		}
+		f.Column = column
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// ParseAggregates 解析聚合参数，逗号分隔，格式为 func 或 func(field)，field为model的json tag名
+//	count,sum(age),max(created_at)
+func ParseAggregates(model interface{}, aggs string) ([]Aggregate, error) {
+	tokens, err := tokenize(aggs)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{model: model, tokens: tokens}
+	var result []Aggregate
+	for {
+		t, err := p.expect(tokenIdent, "aggregate function")
+		if err != nil {
+			return nil, err
+		}
+		agg := Aggregate{Func: strings.ToLower(t.text)}
+		switch agg.Func {
+		case AggFuncCount, AggFuncSum, AggFuncAvg, AggFuncMin, AggFuncMax:
+		default:
+			return nil, &FilterError{t.pos, fmt.Sprintf("unknown aggregate function '%s'", t.text)}
+		}
+		if p.peek().kind == tokenLParen {
+			p.next()
+			t, err = p.expect(tokenIdent, "field")
+			if err != nil {
+				return nil, err
+			}
+			if agg.Column, err = filterColumn(model, t); err != nil {
+				return nil, err
+			}
+			if _, err = p.expect(tokenRParen, "')'"); err != nil {
+				return nil, err
+			}
+		} else if agg.Func != AggFuncCount {
+			return nil, &FilterError{t.pos, fmt.Sprintf("%s requires a field", agg.Func)}
+		}
+		result = append(result, agg)
+
+		t = p.next()
+		if t.kind == tokenEOF {
+			return result, nil
+		}
+		if t.kind != tokenComma {
+			return nil, &FilterError{t.pos, fmt.Sprintf("expect ',', got '%s'", t.text)}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	ma := newTestAgent(t)
+	model := &testUser{}
+	cond, err := ParseFilter(model, `age>=18 and (name in ("a",'b') or name is not null) and id between 1 and 10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args, err := cond.build(ma.db.NewScope(model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `("age" >= ? AND ("name" IN (?) OR "name" IS NOT NULL) AND "id" BETWEEN ? AND ?)`
+	if sql != want {
+		t.Errorf("got %s, want %s", sql, want)
+	}
+	if fmt.Sprint(args) != "[18 [a b] 1 10]" {
+		t.Errorf("got args %v", args)
+	}
+
+	for _, expr := range []string{
+		`unknown = 1`,
+		`age ~ 1`,
+		`age >= `,
+		`name = "a`,
+		`(age = 1`,
+		`age = 1 name = "a"`,
+		`name like 1`,
+	} {
+		if _, err = ParseFilter(model, expr); err == nil {
+			t.Errorf("filter %q should fail", expr)
+		} else if _, ok := err.(*FilterError); !ok {
+			t.Errorf("filter %q got %T", expr, err)
+		}
+	}
+
+	fields, err := ParseSort(model, "-age, name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fields) != "[{Age true} {Name false}]" {
+		t.Errorf("got sort %v", fields)
+	}
+	if _, err = ParseSort(model, "age,,name"); err == nil {
+		t.Error("empty sort field should fail")
+	}
+}
+
+func TestGetEntityList_Filter(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i%3), Age: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	get := func(query url.Values) (int, getEntityListResp) {
+		w := httptest.NewRecorder()
+		path := "/entity/test_user/list?" + query.Encode()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp struct {
+			Data struct {
+				List  []testUser `json:"list"`
+				Total int        `json:"total"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, getEntityListResp{List: resp.Data.List, Total: resp.Data.Total}
+	}
+
+	code, resp := get(url.Values{
+		"filter":    {`age >= 1 and name in ("u1", "u2")`},
+		"sort":      {"name,-age"},
+		"page_size": {"3"},
+	})
+	if code != http.StatusOK || resp.Total != 4 {
+		t.Fatalf("got status %d, total %d", code, resp.Total)
+	}
+	list := resp.List.([]testUser)
+	var got []string
+	for _, u := range list {
+		got = append(got, fmt.Sprintf("%s:%d", u.Name, u.Age))
+	}
+	if fmt.Sprint(got) != "[u1:4 u1:1 u2:5]" {
+		t.Errorf("got %v", got)
+	}
+
+	code, resp = get(url.Values{"filter": {"age > 2"}, "search_field": {"name"}, "search": {"u0"}})
+	if code != http.StatusOK || resp.Total != 1 {
+		t.Errorf("got status %d, total %d", code, resp.Total)
+	}
+
+	for _, query := range []url.Values{
+		{"filter": {"password = 1"}},
+		{"filter": {"age like 1"}},
+		{"sort": {"-password"}},
+		{"sort": {"name"}, "cursor": {""}},
+	} {
+		if code, _ = get(query); code != http.StatusBadRequest {
+			t.Errorf("%v got status %d", query, code)
+		}
+	}
+}
+
+type testProfile struct {
+	Entity
+
+	Nickname string `json:"nickname"`
+	// 只用于展示，没有对应的列
+	Display string `json:"display" gorm:"-"`
+}
+
+func (p *testProfile) SchemaName() string {
+	return "test_profile"
+}
+
+func (p *testProfile) NewFunc() interface{} {
+	return &testProfile{}
+}
+
+func (p *testProfile) NewListFunc() interface{} {
+	var list []*testProfile
+	return &list
+}
+
+func (p *testProfile) GetID() int64 {
+	return p.ID
+}
+
+func (p *testProfile) SetID(id int64) {
+	p.ID = id
+}
+
+func TestGetEntityList_FilterIgnoredField(t *testing.T) {
+	ma := newTestAgent(t, new(testProfile))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	for _, query := range []url.Values{
+		{"filter": {`display = "loki"`}},
+		{"sort": {"-display"}},
+	} {
+		w := httptest.NewRecorder()
+		path := "/entity/test_profile/list?" + query.Encode()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v got status %d", query, w.Code)
+		}
+	}
+}
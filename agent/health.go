@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+)
+
+// DBHealth 单个数据库连接池的健康状态
+type DBHealth struct {
+	Name  string      `json:"name"`
+	Error string      `json:"error,omitempty"`
+	Stats sql.DBStats `json:"stats"`
+}
+
+// Health 主库与所有从库的健康状态，任意一个ping失败即为不健康
+type Health struct {
+	Healthy bool        `json:"healthy"`
+	DBs     []*DBHealth `json:"dbs"`
+}
+
+// Ping 检查主库与所有从库是否可用
+func (ma *MetaAgent) Ping(ctx context.Context) error {
+	health := ma.HealthCheck(ctx)
+	for _, h := range health.DBs {
+		if h.Error != "" {
+			return fmt.Errorf("%s: %s", h.Name, h.Error)
+		}
+	}
+	return nil
+}
+
+// HealthCheck ping主库与所有从库，并返回各连接池的统计信息
+func (ma *MetaAgent) HealthCheck(ctx context.Context) *Health {
+	health := &Health{Healthy: true}
+	health.DBs = append(health.DBs, checkDB(ctx, "primary", ma.db))
+	for i, db := range ma.replicas.dbs {
+		health.DBs = append(health.DBs, checkDB(ctx, fmt.Sprintf("replica-%d", i), db))
+	}
+	for _, h := range health.DBs {
+		if h.Error != "" {
+			health.Healthy = false
+		}
+	}
+	return health
+}
+
+func checkDB(ctx context.Context, name string, db *gorm.DB) *DBHealth {
+	h := &DBHealth{Name: name}
+	sqlDB := db.DB()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		h.Error = err.Error()
+	}
+	h.Stats = sqlDB.Stats()
+	return h
+}
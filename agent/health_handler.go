@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// RegisterHealthHandler 注册存活与就绪探针
+//	GET /health/liveness   进程存活即返回200
+//	GET /health/readiness  数据库全部可用返回200，否则返回503
+func (ma *MetaAgent) RegisterHealthHandler(router gin.IRouter) {
+	if ma == nil {
+		panic("ma can not be nil")
+	}
+	group := router.Group("/health")
+	group.GET("/liveness", livenessHandler)
+	group.GET("/readiness", ma.HealthHandler())
+}
+
+func livenessHandler(c *gin.Context) {
+	success(c, nil)
+}
+
+// HealthHandler 返回数据库健康状态，不健康时http状态码为503，可直接用作就绪探针
+func (ma *MetaAgent) HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+		defer cancel()
+		health := ma.HealthCheck(ctx)
+		if !health.Healthy {
+			jsonOutPutWithStatus(c, http.StatusServiceUnavailable, retError, "unhealthy", health)
+			return
+		}
+		success(c, health)
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetaAgent_HealthCheck(t *testing.T) {
+	ma := newTestAgent(t)
+	if err := ma.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterHealthHandler(router)
+	for _, path := range []string{"/health/liveness", "/health/readiness"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s got status %d", path, w.Code)
+		}
+	}
+
+	ma.db.Close()
+	health := ma.HealthCheck(context.Background())
+	if health.Healthy {
+		t.Error("closed db should be unhealthy")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/readiness", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readiness got status %d", w.Code)
+	}
+}
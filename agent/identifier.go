@@ -0,0 +1,84 @@
+package agent
+
+// 校验进入sql的标识符，schema名必须已注册，列名必须是model的字段，避免sql注入
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// IdentifierError 标识符未通过校验，Column为空表示schema未注册
+type IdentifierError struct {
+	Schema string
+	Column string
+}
+
+func (e *IdentifierError) Error() string {
+	if e.Column == "" {
+		return "schema not register: " + e.Schema
+	}
+	return "unknown column: " + e.Column
+}
+
+// IsIdentifierError 判断err是否为标识符校验失败，支持被包装的错误
+func IsIdentifierError(err error) bool {
+	var identErr *IdentifierError
+	return errors.As(err, &identErr)
+}
+
+// schemaModel 返回已注册schema的对象指针
+func (ma *MetaAgent) schemaModel(schemaName string) (interface{}, error) {
+	mPtr, exist := ma.GetModelPtr(schemaName)
+	if !exist {
+		return nil, &IdentifierError{Schema: schemaName}
+	}
+	return mPtr, nil
+}
+
+// columnName 校验列名属于model并返回数据库列名，支持数据库列名与结构体字段名
+func columnName(scope *gorm.Scope, name string) (string, error) {
+	field, ok := scope.FieldByName(name)
+	if !ok || !field.IsNormal {
+		return "", &IdentifierError{Schema: scope.TableName(), Column: name}
+	}
+	return field.DBName, nil
+}
+
+// quotedColumn 校验列名属于model并返回转义后的列名
+func quotedColumn(scope *gorm.Scope, name string) (string, error) {
+	column, err := columnName(scope, name)
+	if err != nil {
+		return "", err
+	}
+	return scope.Quote(column), nil
+}
+
+// orderClause 校验排序列并生成order by子句，order为空时返回空串
+//	兼容 "column desc"、"column asc" 写法，desc为true时总是倒序
+func orderClause(scope *gorm.Scope, order string, desc bool) (string, error) {
+	if order == "" {
+		return "", nil
+	}
+	parts := strings.Fields(order)
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "desc":
+			desc = true
+			parts = parts[:1]
+		case "asc":
+			parts = parts[:1]
+		}
+	}
+	if len(parts) != 1 {
+		return "", &IdentifierError{Schema: scope.TableName(), Column: order}
+	}
+	column, err := quotedColumn(scope, parts[0])
+	if err != nil {
+		return "", err
+	}
+	if desc {
+		column += " desc"
+	}
+	return column, nil
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMetaAgent_RejectIdentifier(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	users := []*testUser{{Name: "a", Age: 1}, {Name: "b", Age: 2}}
+	for _, u := range users {
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ma.CreateRelation(ctx, &EntityRelation{
+		SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+		TargetSchemaName: "test_user", TargetEntityID: users[1].ID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var list []*testUser
+	if err, _ := ma.QueryEntityListByStringCondition(ctx, &list, 0, 1, "Age", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "b" {
+		t.Errorf("order by age desc got %v", userNames(list))
+	}
+	if err, _ := ma.QueryEntityListByStringCondition(ctx, &list, 0, 1, "age asc", true); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &EntityRelation{SourceSchemaName: "test_user", SourceEntityID: users[0].ID}
+	res, err := ma.ListSourceEntityRelations(ctx, query, 0, 1, nil,
+		map[string]map[string]string{"test_user": {"name": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *res.Relation["test_user"].(*[]*testUser); len(got) != 1 || got[0].Name != "b" {
+		t.Errorf("relation filter got %v", userNames(got))
+	}
+
+	if err = ma.UpdateEntitySingleColumnByStringCondition(ctx, "test_user", "Age", 3, "name=?", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, err := range map[string]error{
+		"order": func() error {
+			err, _ := ma.QueryEntityListByStringCondition(ctx, &list, 0, 1, "id; drop table test_user", false)
+			return err
+		}(),
+		"order by struct": func() error {
+			err, _ := ma.QueryEntityListByStructCondition(ctx, &list, 0, 1, "(select 1)", false, &testUser{})
+			return err
+		}(),
+		"relation filter": func() error {
+			_, err := ma.ListSourceEntityRelations(ctx, query, 0, 1, nil,
+				map[string]map[string]string{"test_user": {"1=1 or name": "b"}})
+			return err
+		}(),
+		"relation include": func() error {
+			_, err := ma.ListSourceEntityRelations(ctx, query, 0, 1, []string{"not_exist"}, nil)
+			return err
+		}(),
+		"update schema": ma.UpdateEntitySingleColumnByStringCondition(ctx, "test_user set age=0 --", "age", 1, "1=1"),
+		"update column": ma.UpdateEntityMultipleColumnByStringCondition(ctx, "test_user",
+			map[string]interface{}{"age=0, name": "x"}, "1=1"),
+		"update by id": ma.UpdateEntitySingleColumnByID(ctx, users[0], "password", "x"),
+	} {
+		if !IsIdentifierError(err) {
+			t.Errorf("%s got %v, want IdentifierError", name, err)
+		}
+	}
+	if n := countUsers(t, ma, "x"); n != 0 {
+		t.Errorf("rejected update changed %d rows", n)
+	}
+}
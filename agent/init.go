@@ -14,12 +14,20 @@ func Init(db *gorm.DB) {
 	}
 	mA.db = db
 	mA.RegisterSchema(new(EntityRelation))
-	err := db.AutoMigrate(new(EntityRelation)).Error
+	err := MigrateEntityRelation(db)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// RegisterReplica 必须在Init执行后才能执行
+func RegisterReplica(db *gorm.DB, weight int) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	mA.RegisterReplica(db, weight)
+}
+
 // InitGinHandler 必须在Init执行后才能执行
 func RegisterGinHandler(router gin.IRouter) {
 	if mA == nil {
@@ -28,6 +36,28 @@ func RegisterGinHandler(router gin.IRouter) {
 	mA.RegisterGinHandler(router)
 }
 
+// RegisterHealthHandler 必须在Init执行后才能执行
+func RegisterHealthHandler(router gin.IRouter) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	mA.RegisterHealthHandler(router)
+}
+
+func Ping(ctx context.Context) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.Ping(ctx)
+}
+
+func HealthCheck(ctx context.Context) *Health {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.HealthCheck(ctx)
+}
+
 func RegisterSchema(schema Schema) {
 	if mA == nil {
 		panic("mA not init")
@@ -42,6 +72,13 @@ func GetDB(ctx context.Context) *gorm.DB {
 	return mA.GetDB(ctx)
 }
 
+func GetReadDB(ctx context.Context) *gorm.DB {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.GetReadDB(ctx)
+}
+
 func GetModelPtr(schemaName string) (interface{}, bool) {
 	if mA == nil {
 		panic("mA not init")
@@ -63,6 +100,27 @@ func CreateEntity(ctx context.Context, mPtr interface{}) error {
 	return mA.CreateEntity(ctx, mPtr)
 }
 
+func CreateEntities(ctx context.Context, modelListPtr interface{}, chunkSize int) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.CreateEntities(ctx, modelListPtr, chunkSize)
+}
+
+func UpdateEntitiesByID(ctx context.Context, modelListPtr interface{}) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.UpdateEntitiesByID(ctx, modelListPtr)
+}
+
+func DeleteEntitiesByID(ctx context.Context, modelListPtr interface{}, chunkSize int) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.DeleteEntitiesByID(ctx, modelListPtr, chunkSize)
+}
+
 // 全量更新，如果某字段为空就意味着更新为空值
 func UpdateEntityByID(ctx context.Context, mPtr interface{}) error {
 	if mA == nil {
@@ -71,6 +129,20 @@ func UpdateEntityByID(ctx context.Context, mPtr interface{}) error {
 	return mA.UpdateEntityByID(ctx, mPtr)
 }
 
+func PatchEntityByID(ctx context.Context, mPtr interface{}, fields ...string) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.PatchEntityByID(ctx, mPtr, fields...)
+}
+
+func MergePatchEntityByID(ctx context.Context, mPtr interface{}, patch []byte) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.MergePatchEntityByID(ctx, mPtr, patch)
+}
+
 func DeleteEntityByID(ctx context.Context, mPtr interface{}) error {
 	if mA == nil {
 		panic("mA not init")
@@ -121,6 +193,49 @@ func QueryEntityListByStructCondition(ctx context.Context, modelListPtr interfac
 	return mA.QueryEntityListByStructCondition(ctx, modelListPtr, pageSize, page, order, desc, filter)
 }
 
+func QueryEntityListByCursor(ctx context.Context, modelListPtr interface{}, pageSize int,
+	cursor string, key string, desc bool, filter ...interface{}) (*CursorPage, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.QueryEntityListByCursor(ctx, modelListPtr, pageSize, cursor, key, desc, filter...)
+}
+
+func NewQuery(schemaName string) *Query {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.NewQuery(schemaName)
+}
+
+func QueryEntityListByQuery(ctx context.Context, modelListPtr interface{}, q *Query) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.QueryEntityListByQuery(ctx, modelListPtr, q)
+}
+
+func CountEntityByQuery(ctx context.Context, q *Query) (int, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.CountEntityByQuery(ctx, q)
+}
+
+func DeleteEntityByQuery(ctx context.Context, q *Query) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.DeleteEntityByQuery(ctx, q)
+}
+
+func UpdateEntityColumnsByQuery(ctx context.Context, q *Query, columns map[string]interface{}) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.UpdateEntityColumnsByQuery(ctx, q, columns)
+}
+
 func ListSourceEntityRelations(ctx context.Context, query *EntityRelation,
 	pageSize, page int, relationsInclude []string, filter map[string]map[string]string) (*RelationList, error) {
 	if mA == nil {
@@ -129,6 +244,14 @@ func ListSourceEntityRelations(ctx context.Context, query *EntityRelation,
 	return mA.ListSourceEntityRelations(ctx, query, pageSize, page, relationsInclude, filter)
 }
 
+func ListTargetEntityRelations(ctx context.Context, query *EntityRelation,
+	pageSize, page int, relationsInclude []string, filter map[string]map[string]string) (*RelationList, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.ListTargetEntityRelations(ctx, query, pageSize, page, relationsInclude, filter)
+}
+
 func QueryRelationByUuid(ctx context.Context, filter *EntityRelation) (*EntityRelation, error) {
 	return mA.QueryRelationByUuid(ctx, filter)
 }
@@ -154,6 +277,20 @@ func CreateRelation(ctx context.Context, relation *EntityRelation) (err error) {
 	return mA.CreateRelation(ctx, relation)
 }
 
+func UpsertRelation(ctx context.Context, relation *EntityRelation) (err error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.UpsertRelation(ctx, relation)
+}
+
+func UpsertEntity(ctx context.Context, mPtr interface{}, opts *UpsertOptions) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.UpsertEntity(ctx, mPtr, opts)
+}
+
 func WithTransaction(ctx context.Context, scopeDDLs txHandler) (err error) {
 	if mA == nil {
 		panic("mA not init")
@@ -161,6 +298,23 @@ func WithTransaction(ctx context.Context, scopeDDLs txHandler) (err error) {
 	return mA.WithTransaction(ctx, scopeDDLs)
 }
 
+func WithTransactionOptions(ctx context.Context, opts *TxOptions, scopeDDLs txHandler) (err error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.WithTransactionOptions(ctx, opts, scopeDDLs)
+}
+
+func LockEntitiesByID(ctx context.Context, schemaName string, ids []int64, opts *LockOptions) (interface{}, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.LockEntitiesByID(ctx, schemaName, ids, opts)
+}
+
+// LockRecordByID 在事务中对schema的多行数据加排他锁
+//
+// Deprecated: 使用LockEntitiesByID
 func LockRecordByID(ctx context.Context, schema string, ids []int64) error {
 	if mA == nil {
 		panic("mA not init")
@@ -182,4 +336,81 @@ func UpdateEntityMultipleColumnByStringCondition(
 		panic("mA not init")
 	}
 	return mA.UpdateEntityMultipleColumnByStringCondition(ctx, schema, columns, query, args...)
-}
\ No newline at end of file
+}
+
+func NewAggregateQuery(q *Query) *AggregateQuery {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.NewAggregateQuery(q)
+}
+
+func AggregateEntity(ctx context.Context, a *AggregateQuery) ([]map[string]interface{}, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.AggregateEntity(ctx, a)
+}
+
+func ScanAggregate(ctx context.Context, a *AggregateQuery, resultListPtr interface{}) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.ScanAggregate(ctx, a, resultListPtr)
+}
+
+func IterateEntity(ctx context.Context, q *Query, batchSize int, fn func(mPtr interface{}) error) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.IterateEntity(ctx, q, batchSize, fn)
+}
+
+func StreamEntity(ctx context.Context, q *Query, batchSize int) (<-chan interface{}, <-chan error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.StreamEntity(ctx, q, batchSize)
+}
+
+func RegisterRelationType(sourceSchema, relationType, targetSchema string, cardinality Cardinality) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	mA.RegisterRelationType(sourceSchema, relationType, targetSchema, cardinality)
+}
+
+func SetRelationStatus(ctx context.Context, relation *EntityRelation, status string) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.SetRelationStatus(ctx, relation, status)
+}
+
+func EnableRelation(ctx context.Context, relation *EntityRelation) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.EnableRelation(ctx, relation)
+}
+
+func DisableRelation(ctx context.Context, relation *EntityRelation) error {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.DisableRelation(ctx, relation)
+}
+
+func SetDeletePolicy(schemaName string, policy DeletePolicy) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	mA.SetDeletePolicy(schemaName, policy)
+}
+
+func TraverseRelations(ctx context.Context, schemaName string, id int64, opts *GraphOptions) (*Graph, error) {
+	if mA == nil {
+		panic("mA not init")
+	}
+	return mA.TraverseRelations(ctx, schemaName, id, opts)
+}
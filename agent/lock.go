@@ -0,0 +1,113 @@
+package agent
+
+// 事务内的行锁
+
+import (
+	"context"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"sort"
+)
+
+var ErrNotInTransaction = errors.New("lock must be called inside WithTransaction")
+
+// LockMode 行锁类型
+type LockMode int
+
+const (
+	// LockForUpdate 排他锁
+	LockForUpdate LockMode = iota
+	// LockForShare 共享锁
+	LockForShare
+)
+
+// LockWait 行已被锁住时的等待策略
+type LockWait int
+
+const (
+	// LockWaitDefault 等待直到获取锁或超时
+	LockWaitDefault LockWait = iota
+	// LockNoWait 立即返回错误
+	LockNoWait
+	// LockSkipLocked 跳过已被锁住的行
+	LockSkipLocked
+)
+
+// LockOptions 行锁选项，nil等同于LockForUpdate + LockWaitDefault
+type LockOptions struct {
+	Mode LockMode
+	Wait LockWait
+}
+
+// lockClause 生成各方言的锁子句
+//	mysql 5.7不支持FOR SHARE，无等待策略时使用LOCK IN SHARE MODE
+//	sqlite没有行锁，写事务会锁住整个库，返回空
+func (opts *LockOptions) lockClause(dialect string) string {
+	if dialect == "sqlite3" {
+		return ""
+	}
+	var clause string
+	switch opts.Mode {
+	case LockForShare:
+		if dialect == "mysql" && opts.Wait == LockWaitDefault {
+			return "LOCK IN SHARE MODE"
+		}
+		clause = "FOR SHARE"
+	default:
+		clause = "FOR UPDATE"
+	}
+	switch opts.Wait {
+	case LockNoWait:
+		clause += " NOWAIT"
+	case LockSkipLocked:
+		clause += " SKIP LOCKED"
+	}
+	return clause
+}
+
+// LockEntitiesByID 在事务中按id升序锁住schema的多行数据，并返回锁住的数据
+//	返回值为GetModelListPtr得到的对象切片指针，使用LockSkipLocked时可能少于ids
+//	sql like:
+//		select * from {schema_name}
+//		where id in (?)
+//		order by id
+//		for update [nowait | skip locked]
+func (ma *MetaAgent) LockEntitiesByID(ctx context.Context, schemaName string, ids []int64, opts *LockOptions) (interface{}, error) {
+	tx, err := ma.getTxConnFromContext(ctx)
+	if err != nil {
+		return nil, ErrNotInTransaction
+	}
+	listPtr, exist := ma.GetModelListPtr(schemaName)
+	if !exist {
+		return nil, &IdentifierError{Schema: schemaName}
+	}
+	if len(ids) == 0 {
+		return listPtr, nil
+	}
+	if opts == nil {
+		opts = &LockOptions{}
+	}
+
+	// 按id升序加锁，避免不同事务加锁顺序不一致导致死锁
+	sortedIds := make([]int64, len(ids))
+	copy(sortedIds, ids)
+	sort.Slice(sortedIds, func(i, j int) bool { return sortedIds[i] < sortedIds[j] })
+
+	var db *gorm.DB = tx
+	if clause := opts.lockClause(tx.Dialect().GetName()); clause != "" {
+		db = db.Set("gorm:query_option", clause)
+	}
+	err = db.Where("id in (?)", sortedIds).Order("id").Find(listPtr).Error
+	if err != nil {
+		return nil, err
+	}
+	return listPtr, nil
+}
+
+// LockRecordByID 在事务中对schema的多行数据加排他锁
+//
+// Deprecated: 使用LockEntitiesByID
+func (ma *MetaAgent) LockRecordByID(ctx context.Context, schema string, ids []int64) error {
+	_, err := ma.LockEntitiesByID(ctx, schema, ids, nil)
+	return err
+}
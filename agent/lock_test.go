@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLockOptions_lockClause(t *testing.T) {
+	cases := []struct {
+		opts    LockOptions
+		dialect string
+		want    string
+	}{
+		{LockOptions{}, "mysql", "FOR UPDATE"},
+		{LockOptions{Mode: LockForShare}, "mysql", "LOCK IN SHARE MODE"},
+		{LockOptions{Mode: LockForShare, Wait: LockNoWait}, "mysql", "FOR SHARE NOWAIT"},
+		{LockOptions{Wait: LockSkipLocked}, "postgres", "FOR UPDATE SKIP LOCKED"},
+		{LockOptions{Mode: LockForShare}, "postgres", "FOR SHARE"},
+		{LockOptions{}, "sqlite3", ""},
+	}
+	for _, c := range cases {
+		if got := c.opts.lockClause(c.dialect); got != c.want {
+			t.Errorf("%+v on %s got %q, want %q", c.opts, c.dialect, got, c.want)
+		}
+	}
+}
+
+func TestMetaAgent_LockEntitiesByID(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ma.CreateEntity(ctx, &testUser{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ma.LockEntitiesByID(ctx, "test_user", []int64{1}, nil); err != ErrNotInTransaction {
+		t.Errorf("lock outside tx got err %v", err)
+	}
+
+	err := ma.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := ma.LockEntitiesByID(ctx, "test_user;drop table test_user", []int64{1}, nil); err == nil {
+			t.Error("unregistered schema should fail")
+		}
+		listPtr, err := ma.LockEntitiesByID(ctx, "test_user", []int64{3, 1}, nil)
+		if err != nil {
+			return err
+		}
+		list := *listPtr.(*[]*testUser)
+		if len(list) != 2 || list[0].Name != "a" || list[1].Name != "c" {
+			t.Errorf("unexpected locked rows: %+v", list)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,357 @@
+package agent
+
+// 类型化的查询构造器，编译为参数化sql，列名均经过schema校验
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// 条件操作符
+const (
+	opEq      = "="
+	opNe      = "<>"
+	opLt      = "<"
+	opLte     = "<="
+	opGt      = ">"
+	opGte     = ">="
+	opIn      = "IN"
+	opNotIn   = "NOT IN"
+	opBetween = "BETWEEN"
+	opLike    = "LIKE"
+	opIsNull  = "IS NULL"
+	opNotNull = "IS NOT NULL"
+	opAnd     = "AND"
+	opOr      = "OR"
+)
+
+// Cond 查询条件，通过Eq、In、And等函数构造
+type Cond struct {
+	op       string
+	column   string
+	values   []interface{}
+	children []Cond
+}
+
+func Eq(column string, value interface{}) Cond {
+	return Cond{op: opEq, column: column, values: []interface{}{value}}
+}
+
+func Ne(column string, value interface{}) Cond {
+	return Cond{op: opNe, column: column, values: []interface{}{value}}
+}
+
+func Lt(column string, value interface{}) Cond {
+	return Cond{op: opLt, column: column, values: []interface{}{value}}
+}
+
+func Lte(column string, value interface{}) Cond {
+	return Cond{op: opLte, column: column, values: []interface{}{value}}
+}
+
+func Gt(column string, value interface{}) Cond {
+	return Cond{op: opGt, column: column, values: []interface{}{value}}
+}
+
+func Gte(column string, value interface{}) Cond {
+	return Cond{op: opGte, column: column, values: []interface{}{value}}
+}
+
+// Like pattern中的%、_需要调用方自行转义
+func Like(column string, pattern string) Cond {
+	return Cond{op: opLike, column: column, values: []interface{}{pattern}}
+}
+
+func In(column string, values ...interface{}) Cond {
+	return Cond{op: opIn, column: column, values: values}
+}
+
+func NotIn(column string, values ...interface{}) Cond {
+	return Cond{op: opNotIn, column: column, values: values}
+}
+
+func Between(column string, from, to interface{}) Cond {
+	return Cond{op: opBetween, column: column, values: []interface{}{from, to}}
+}
+
+func IsNull(column string) Cond {
+	return Cond{op: opIsNull, column: column}
+}
+
+func NotNull(column string) Cond {
+	return Cond{op: opNotNull, column: column}
+}
+
+func And(conds ...Cond) Cond {
+	return Cond{op: opAnd, children: conds}
+}
+
+func Or(conds ...Cond) Cond {
+	return Cond{op: opOr, children: conds}
+}
+
+// build 编译为带?占位符的sql片段
+func (c Cond) build(scope *gorm.Scope) (string, []interface{}, error) {
+	switch c.op {
+	case opAnd, opOr:
+		if len(c.children) == 0 {
+			return "", nil, fmt.Errorf("%s group can not be empty", c.op)
+		}
+		parts := make([]string, len(c.children))
+		var args []interface{}
+		for i, child := range c.children {
+			part, childArgs, err := child.build(scope)
+			if err != nil {
+				return "", nil, err
+			}
+			parts[i] = part
+			args = append(args, childArgs...)
+		}
+		return "(" + strings.Join(parts, " "+c.op+" ") + ")", args, nil
+	}
+
+	column, err := quotedColumn(scope, c.column)
+	if err != nil {
+		return "", nil, err
+	}
+	switch c.op {
+	case opEq, opNe, opLt, opLte, opGt, opGte, opLike:
+		return column + " " + c.op + " ?", c.values, nil
+	case opIn, opNotIn:
+		// 空集合: in恒为假，not in恒为真
+		if len(c.values) == 0 {
+			if c.op == opIn {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		return column + " " + c.op + " (?)", []interface{}{c.values}, nil
+	case opBetween:
+		return column + " BETWEEN ? AND ?", c.values, nil
+	case opIsNull, opNotNull:
+		return column + " " + c.op, nil, nil
+	}
+	return "", nil, errors.New("unknown operator: " + c.op)
+}
+
+type orderBy struct {
+	column string
+	desc   bool
+}
+
+// Query 查询构造器，通过MetaAgent.NewQuery创建，可链式调用
+//	q := ma.NewQuery("user").
+//		Where(Gte("age", 18), Or(Eq("status", "A"), IsNull("status"))).
+//		OrderBy("created_at", true).OrderBy("id", false).
+//		Limit(20).Offset(40).
+//		Select("id", "name")
+type Query struct {
+	schemaName string
+	model      interface{}
+	err        error
+
+	conds  []Cond
+	orders []orderBy
+	fields []string
+	limit  int
+	offset int
+}
+
+// NewQuery 创建schema的查询构造器，schema未注册时错误在执行查询时返回
+func (ma *MetaAgent) NewQuery(schemaName string) *Query {
+	q := &Query{schemaName: schemaName}
+	var exist bool
+	if q.model, exist = ma.GetModelPtr(schemaName); !exist {
+		q.err = &IdentifierError{Schema: schemaName}
+	}
+	return q
+}
+
+// Where 添加条件，多次调用之间为and关系
+func (q *Query) Where(conds ...Cond) *Query {
+	q.conds = append(q.conds, conds...)
+	return q
+}
+
+// OrderBy 添加排序列，按调用顺序排序
+func (q *Query) OrderBy(column string, desc bool) *Query {
+	q.orders = append(q.orders, orderBy{column: column, desc: desc})
+	return q
+}
+
+// Select 只查询指定列
+func (q *Query) Select(columns ...string) *Query {
+	q.fields = append(q.fields, columns...)
+	return q
+}
+
+// Limit 为0时不限制
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
+// Build 编译where条件为带?占位符的sql片段及参数
+func (q *Query) Build(db *gorm.DB) (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	if len(q.conds) == 0 {
+		return "", nil, nil
+	}
+	return And(q.conds...).build(db.NewScope(q.model))
+}
+
+// Filter 编译where条件为filter参数，可传入QueryEntityListByStringCondition等方法，无条件时返回nil
+//	filter, err := q.Filter(ma.GetDB(ctx))
+//	ma.QueryEntityListByStringCondition(ctx, list, 10, 1, "id", true, filter...)
+func (q *Query) Filter(db *gorm.DB) ([]interface{}, error) {
+	cond, args, err := q.Build(db)
+	if err != nil || cond == "" {
+		return nil, err
+	}
+	return append([]interface{}{cond}, args...), nil
+}
+
+// apply 将where条件、排序、分页、投影应用到db上
+func (q *Query) apply(db *gorm.DB, withPage bool) (*gorm.DB, error) {
+	cond, args, err := q.Build(db)
+	if err != nil {
+		return nil, err
+	}
+	scope := db.NewScope(q.model)
+	db = db.Model(q.model)
+	if cond != "" {
+		db = db.Where(cond, args...)
+	}
+	if !withPage {
+		return db, nil
+	}
+	for _, o := range q.orders {
+		column, err := quotedColumn(scope, o.column)
+		if err != nil {
+			return nil, err
+		}
+		if o.desc {
+			column += " desc"
+		}
+		db = db.Order(column)
+	}
+	if len(q.fields) > 0 {
+		columns := make([]string, len(q.fields))
+		for i, f := range q.fields {
+			if columns[i], err = quotedColumn(scope, f); err != nil {
+				return nil, err
+			}
+		}
+		db = db.Select(columns)
+	}
+	if q.limit > 0 {
+		db = db.Limit(q.limit)
+	}
+	if q.offset > 0 {
+		db = db.Offset(q.offset)
+	}
+	return db, nil
+}
+
+// QueryEntityListByQuery 按查询构造器查询，modelListPtr须为该schema的对象切片指针
+//	sql like:
+//		select {fields...} from {schema_name}
+//		where {conds...}
+//		order by {column1} [desc], {column2} [desc]
+//		limit {limit} offset {offset}
+func (ma *MetaAgent) QueryEntityListByQuery(ctx context.Context, modelListPtr interface{}, q *Query) error {
+	db, err := q.apply(ma.GetReadDB(ctx), true)
+	if err != nil {
+		return err
+	}
+	return db.Find(modelListPtr).Error
+}
+
+// CountEntityByQuery 统计满足查询条件的数据条数，忽略排序、分页、投影
+func (ma *MetaAgent) CountEntityByQuery(ctx context.Context, q *Query) (total int, err error) {
+	db, err := q.apply(ma.GetReadDB(ctx), false)
+	if err != nil {
+		return 0, err
+	}
+	err = db.Count(&total).Error
+	return
+}
+
+// DeleteEntityByQuery 删除满足查询条件的数据，没有条件时拒绝执行，按schema的删除策略处理relation
+//	如果model实现了软删除，则查出满足条件的数据后在一个事务中逐条执行DeleteEntityByID
+func (ma *MetaAgent) DeleteEntityByQuery(ctx context.Context, q *Query) error {
+	if len(q.conds) == 0 {
+		return errors.New("delete without condition is not allowed")
+	}
+	if _, ok := q.model.(SoftDeleter); ok {
+		return ma.WithTransaction(ctx, func(ctx context.Context) error {
+			db, err := q.apply(ma.GetDB(ctx), false)
+			if err != nil {
+				return err
+			}
+			listPtr := q.model.(Schema).NewListFunc()
+			if err = db.Find(listPtr).Error; err != nil {
+				return err
+			}
+			elems, err := listElems(listPtr)
+			if err != nil {
+				return err
+			}
+			for _, mPtr := range elems {
+				if err = ma.DeleteEntityByID(ctx, mPtr); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	ids := func(ctx context.Context) ([]int64, error) {
+		db, err := q.apply(ma.GetDB(ctx), false)
+		if err != nil {
+			return nil, err
+		}
+		var ids []int64
+		err = db.Unscoped().Pluck("id", &ids).Error
+		return ids, err
+	}
+	return ma.withDeletePolicy(ctx, q.model, ids, func(ctx context.Context) error {
+		db, err := q.apply(ma.GetDB(ctx), false)
+		if err != nil {
+			return err
+		}
+		return db.Unscoped().Delete(q.model).Error
+	})
+}
+
+// UpdateEntityColumnsByQuery 更新满足查询条件的数据的指定列，没有条件时拒绝执行，
+//	model实现了Versioner时version加1
+func (ma *MetaAgent) UpdateEntityColumnsByQuery(ctx context.Context, q *Query, columns map[string]interface{}) error {
+	if len(q.conds) == 0 {
+		return errors.New("update without condition is not allowed")
+	}
+	db, err := q.apply(ma.GetDB(ctx), false)
+	if err != nil {
+		return err
+	}
+	scope := db.NewScope(q.model)
+	updates := make(map[string]interface{}, len(columns))
+	for name, value := range columns {
+		column, err := columnName(scope, name)
+		if err != nil {
+			return err
+		}
+		updates[column] = value
+	}
+	bumpVersion(q.model, updates)
+	return db.Updates(updates).Error
+}
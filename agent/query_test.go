@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestQuery_Build(t *testing.T) {
+	ma := newTestAgent(t)
+	q := ma.NewQuery("test_user").Where(
+		Gte("age", 18),
+		Or(In("name", "a", "b"), IsNull("name"), Like("Name", "c%")),
+		Between("id", 1, 10),
+	)
+	cond, args, err := q.Build(ma.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `("age" >= ? AND ("name" IN (?) OR "name" IS NULL OR "name" LIKE ?) AND "id" BETWEEN ? AND ?)`
+	if cond != want {
+		t.Errorf("got %s, want %s", cond, want)
+	}
+	if fmt.Sprint(args) != "[18 [a b] c% 1 10]" {
+		t.Errorf("got args %v", args)
+	}
+
+	for _, q := range []*Query{
+		ma.NewQuery("not_exist"),
+		ma.NewQuery("test_user").Where(Eq("age; drop table test_user", 1)),
+		ma.NewQuery("test_user").Where(Or()),
+	} {
+		if _, _, err = q.Build(ma.db); err == nil {
+			t.Errorf("query %+v should fail", q)
+		}
+	}
+}
+
+func TestMetaAgent_QueryByQuery(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := ma.CreateEntity(ctx, &testUser{Name: fmt.Sprintf("u%d", i), Age: i % 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := ma.NewQuery("test_user").Where(Ne("age", 0)).
+		OrderBy("age", true).OrderBy("id", false).
+		Select("id", "name").Limit(3).Offset(1)
+	var list []*testUser
+	if err := ma.QueryEntityListByQuery(ctx, &list, q); err != nil {
+		t.Fatal(err)
+	}
+	// age: u1=1 u2=2 u4=1 u5=2, 排序后 u2 u5 u1 u4
+	if fmt.Sprint(userNames(list)) != "[u5 u1 u4]" || list[0].Age != 0 {
+		t.Errorf("got %v, age should not be selected: %+v", userNames(list), list[0])
+	}
+
+	total, err := ma.CountEntityByQuery(ctx, q)
+	if err != nil || total != 4 {
+		t.Errorf("count got %d, err %v", total, err)
+	}
+
+	filter, err := q.Filter(ma.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list = nil
+	if err, total = ma.QueryEntityListByStringCondition(ctx, &list, 0, 0, "id", false, filter...); err != nil || total != 4 {
+		t.Errorf("filter got %d, err %v", total, err)
+	}
+
+	update := ma.NewQuery("test_user").Where(Eq("age", 2))
+	if err = ma.UpdateEntityColumnsByQuery(ctx, update, map[string]interface{}{"name": "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if total, _ = ma.CountEntityByQuery(ctx, ma.NewQuery("test_user").Where(Eq("name", "two"))); total != 2 {
+		t.Errorf("update got %d", total)
+	}
+	if err = ma.DeleteEntityByQuery(ctx, ma.NewQuery("test_user")); err == nil {
+		t.Error("delete without condition should fail")
+	}
+	if err = ma.DeleteEntityByQuery(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+	if total, _ = ma.CountEntityByQuery(ctx, ma.NewQuery("test_user")); total != 3 {
+		t.Errorf("delete left %d", total)
+	}
+}
+
+type testNote struct {
+	Entity
+
+	Title   string `json:"title"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (n *testNote) SchemaName() string {
+	return "test_note"
+}
+
+func (n *testNote) NewFunc() interface{} {
+	return &testNote{}
+}
+
+func (n *testNote) NewListFunc() interface{} {
+	var list []*testNote
+	return &list
+}
+
+func (n *testNote) GetID() int64 {
+	return n.ID
+}
+
+func (n *testNote) SetID(id int64) {
+	n.ID = id
+}
+
+func (n *testNote) SoftDelete() {
+	n.Deleted = true
+}
+
+func TestMetaAgent_DeleteEntityByQuery_SoftDelete(t *testing.T) {
+	ma := newTestAgent(t, new(testNote))
+	ctx := context.Background()
+	for _, title := range []string{"a", "a", "b"} {
+		if err := ma.CreateEntity(ctx, &testNote{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ma.DeleteEntityByQuery(ctx, ma.NewQuery("test_note").Where(Eq("title", "a"))); err != nil {
+		t.Fatal(err)
+	}
+	if total, _ := ma.CountEntityByQuery(ctx, ma.NewQuery("test_note")); total != 3 {
+		t.Errorf("soft delete should keep rows, got %d", total)
+	}
+	deleted, _ := ma.CountEntityByQuery(ctx, ma.NewQuery("test_note").Where(Eq("deleted", true)))
+	if deleted != 2 {
+		t.Errorf("soft deleted %d rows, want 2", deleted)
+	}
+}
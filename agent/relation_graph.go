@@ -0,0 +1,346 @@
+package agent
+
+// relation图遍历，从一个entity出发按relation广度优先遍历多跳
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const defaultGraphMaxDepth = 3
+
+// GraphDirection 遍历方向
+type GraphDirection string
+
+const (
+	// 沿source -> target方向遍历
+	GraphOutgoing GraphDirection = "out"
+	// 沿target -> source方向遍历
+	GraphIncoming GraphDirection = "in"
+	// 忽略relation方向
+	GraphBoth GraphDirection = "both"
+)
+
+// GraphOptions 遍历选项
+type GraphOptions struct {
+	// 最大跳数，为0时使用默认值3
+	MaxDepth int
+	// 为空时为GraphOutgoing
+	Direction GraphDirection
+	// 只经过这些schema的entity，为空表示不限制，起点不受限制
+	Schemas []string
+	// 只经过这些类型的relation，为空表示不限制
+	RelationTypes []string
+	// 使用递归CTE在数据库中计算可达的entity，要求MySQL 8.0+、PostgreSQL或SQLite 3.8.3+，
+	// 只支持GraphOutgoing与GraphIncoming
+	UseCTE bool
+}
+
+// GraphNode 遍历到的entity，Depth为距起点的最少跳数
+type GraphNode struct {
+	SchemaName string      `json:"schema_name"`
+	ID         int64       `json:"id"`
+	Depth      int         `json:"depth"`
+	Entity     interface{} `json:"entity"`
+}
+
+// Graph 遍历结果，Nodes按广度优先顺序排列，第一个为起点
+type Graph struct {
+	Nodes []*GraphNode      `json:"nodes"`
+	Edges []*EntityRelation `json:"edges"`
+}
+
+type graphNodeKey struct {
+	schemaName string
+	id         int64
+}
+
+func (opts *GraphOptions) check() error {
+	if opts.MaxDepth < 0 {
+		return errors.New("max depth can not be negative")
+	}
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = defaultGraphMaxDepth
+	}
+	switch opts.Direction {
+	case "":
+		opts.Direction = GraphOutgoing
+	case GraphOutgoing, GraphIncoming:
+	case GraphBoth:
+		if opts.UseCTE {
+			return errors.New("cte does not support direction both")
+		}
+	default:
+		return errors.New("unknown graph direction: " + string(opts.Direction))
+	}
+	return nil
+}
+
+// allowSchema 是否可以经过该schema
+func (opts *GraphOptions) allowSchema(schemaName string) bool {
+	if len(opts.Schemas) == 0 {
+		return true
+	}
+	for _, s := range opts.Schemas {
+		if s == schemaName {
+			return true
+		}
+	}
+	return false
+}
+
+// TraverseRelations 从entity出发广度优先遍历relation，返回遍历到的entity与经过的relation
+//	已访问的entity不会重复访问，relation成环时遍历也会终止
+//	默认只经过ENABLE的relation，ctx通过WithDisabledRelations创建时包含已禁用的relation
+//	每一跳 sql like:
+//		select (column1, column2,...) from entity_relation
+//		where ((source_schema_name={schema} and source_entity_id in ({ids})) or ...)
+//		and status='ENABLE' [and relation_type in ({relation_types})]
+//	UseCTE时 sql like:
+//		with recursive walk(schema_name, entity_id, depth) as (
+//			select {schema_name}, {id}, 0
+//			union
+//			select r.target_schema_name, r.target_entity_id, w.depth + 1
+//			from walk w join entity_relation r
+//			on r.source_schema_name = w.schema_name and r.source_entity_id = w.entity_id
+//			where w.depth < {max_depth} and ...
+//		)
+//		select schema_name, entity_id, min(depth) as depth from walk group by schema_name, entity_id
+func (ma *MetaAgent) TraverseRelations(ctx context.Context, schemaName string, id int64, opts *GraphOptions) (*Graph, error) {
+	if opts == nil {
+		opts = &GraphOptions{}
+	}
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	for _, s := range opts.Schemas {
+		if _, exist := ma.pool[s]; !exist {
+			return nil, &IdentifierError{Schema: s}
+		}
+	}
+	if err := checkRelationEndpoint(ctx, ma, schemaName, id); err != nil {
+		return nil, err
+	}
+
+	var graph *Graph
+	var err error
+	if opts.UseCTE {
+		graph, err = ma.traverseByCTE(ctx, schemaName, id, opts)
+	} else {
+		graph, err = ma.traverseByBFS(ctx, schemaName, id, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return graph, ma.loadGraphEntities(ctx, graph)
+}
+
+// relationEnds 返回relation在遍历方向上的起止端
+func relationEnds(r *EntityRelation, reverse bool) (from, to graphNodeKey) {
+	from = graphNodeKey{r.SourceSchemaName, r.SourceEntityID}
+	to = graphNodeKey{r.TargetSchemaName, r.TargetEntityID}
+	if reverse {
+		return to, from
+	}
+	return from, to
+}
+
+// relationFilters relation的状态、类型过滤条件
+func relationFilters(ctx context.Context, opts *GraphOptions) []Cond {
+	var conds []Cond
+	if !isWithDisabledRelations(ctx) {
+		conds = append(conds, Eq("status", EntityRelationStatusENABLE))
+	}
+	if len(opts.RelationTypes) > 0 {
+		types := make([]interface{}, len(opts.RelationTypes))
+		for i, t := range opts.RelationTypes {
+			types[i] = t
+		}
+		conds = append(conds, In("relation_type", types...))
+	}
+	return conds
+}
+
+// frontierCond 匹配从frontier中的entity出发的relation
+func frontierCond(frontier map[string][]interface{}, reverse bool) Cond {
+	schemaColumn, idColumn := "source_schema_name", "source_entity_id"
+	if reverse {
+		schemaColumn, idColumn = "target_schema_name", "target_entity_id"
+	}
+	var conds []Cond
+	for schemaName, ids := range frontier {
+		conds = append(conds, And(Eq(schemaColumn, schemaName), In(idColumn, ids...)))
+	}
+	return Or(conds...)
+}
+
+func (ma *MetaAgent) traverseByBFS(ctx context.Context, schemaName string, id int64, opts *GraphOptions) (*Graph, error) {
+	start := graphNodeKey{schemaName, id}
+	graph := &Graph{Nodes: []*GraphNode{{SchemaName: schemaName, ID: id}}}
+	visited := map[graphNodeKey]bool{start: true}
+	seenEdges := map[int64]bool{}
+	frontier := []graphNodeKey{start}
+	filters := relationFilters(ctx, opts)
+
+	for depth := 1; depth <= opts.MaxDepth && len(frontier) > 0; depth++ {
+		inFrontier := make(map[graphNodeKey]bool, len(frontier))
+		ids := map[string][]interface{}{}
+		for _, n := range frontier {
+			inFrontier[n] = true
+			ids[n.schemaName] = append(ids[n.schemaName], n.id)
+		}
+		var ends []Cond
+		if opts.Direction != GraphIncoming {
+			ends = append(ends, frontierCond(ids, false))
+		}
+		if opts.Direction != GraphOutgoing {
+			ends = append(ends, frontierCond(ids, true))
+		}
+		q := ma.NewQuery(new(EntityRelation).SchemaName()).
+			Where(Or(ends...)).Where(filters...).OrderBy("id", false)
+		var relations []*EntityRelation
+		if err := ma.QueryEntityListByQuery(ctx, &relations, q); err != nil {
+			return nil, err
+		}
+
+		var next []graphNodeKey
+		for _, r := range relations {
+			for _, reverse := range []bool{false, true} {
+				if (reverse && opts.Direction == GraphOutgoing) || (!reverse && opts.Direction == GraphIncoming) {
+					continue
+				}
+				from, to := relationEnds(r, reverse)
+				if !inFrontier[from] || !opts.allowSchema(to.schemaName) {
+					continue
+				}
+				if !seenEdges[r.ID] {
+					seenEdges[r.ID] = true
+					graph.Edges = append(graph.Edges, r)
+				}
+				if !visited[to] {
+					visited[to] = true
+					next = append(next, to)
+					graph.Nodes = append(graph.Nodes, &GraphNode{SchemaName: to.schemaName, ID: to.id, Depth: depth})
+				}
+			}
+		}
+		frontier = next
+	}
+	return graph, nil
+}
+
+func (ma *MetaAgent) traverseByCTE(ctx context.Context, schemaName string, id int64, opts *GraphOptions) (*Graph, error) {
+	reverse := opts.Direction == GraphIncoming
+	fromSchema, fromID, toSchema, toID := "source_schema_name", "source_entity_id", "target_schema_name", "target_entity_id"
+	if reverse {
+		fromSchema, fromID, toSchema, toID = toSchema, toID, fromSchema, fromID
+	}
+
+	// 递归部分的过滤条件
+	where := []string{"w.depth < ?"}
+	args := []interface{}{schemaName, id, opts.MaxDepth}
+	if !isWithDisabledRelations(ctx) {
+		where = append(where, "r.status = ?")
+		args = append(args, EntityRelationStatusENABLE)
+	}
+	if len(opts.RelationTypes) > 0 {
+		where = append(where, "r.relation_type IN (?)")
+		args = append(args, opts.RelationTypes)
+	}
+	if len(opts.Schemas) > 0 {
+		where = append(where, "r."+toSchema+" IN (?)")
+		args = append(args, opts.Schemas)
+	}
+	// 起点的列类型决定递归结果的列类型
+	db := ma.GetReadDB(ctx)
+	startColumns := "?, ?"
+	switch db.Dialect().GetName() {
+	case "mysql":
+		startColumns = "CAST(? AS CHAR(255)), CAST(? AS SIGNED)"
+	case "postgres":
+		startColumns = "CAST(? AS VARCHAR(255)), CAST(? AS BIGINT)"
+	}
+	sql := fmt.Sprintf(`WITH RECURSIVE walk(schema_name, entity_id, depth) AS (
+	SELECT %s, 0
+	UNION
+	SELECT r.%s, r.%s, w.depth + 1
+	FROM walk w JOIN entity_relation r ON r.%s = w.schema_name AND r.%s = w.entity_id
+	WHERE %s
+)
+SELECT schema_name, entity_id, MIN(depth) AS depth FROM walk GROUP BY schema_name, entity_id ORDER BY depth, schema_name, entity_id`,
+		startColumns, toSchema, toID, fromSchema, fromID, strings.Join(where, " AND "))
+
+	var rows []struct {
+		SchemaName string
+		EntityID   int64
+		Depth      int
+	}
+	if err := db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	graph := &Graph{}
+	nodes := map[string][]interface{}{}
+	depths := map[graphNodeKey]int{}
+	for _, row := range rows {
+		graph.Nodes = append(graph.Nodes, &GraphNode{SchemaName: row.SchemaName, ID: row.EntityID, Depth: row.Depth})
+		depths[graphNodeKey{row.SchemaName, row.EntityID}] = row.Depth
+		if row.Depth < opts.MaxDepth {
+			nodes[row.SchemaName] = append(nodes[row.SchemaName], row.EntityID)
+		}
+	}
+	if len(nodes) == 0 {
+		return graph, nil
+	}
+
+	// 查询经过的relation: 起点在最大深度之内，终点可达
+	q := ma.NewQuery(new(EntityRelation).SchemaName()).
+		Where(frontierCond(nodes, reverse)).Where(relationFilters(ctx, opts)...).OrderBy("id", false)
+	var relations []*EntityRelation
+	if err := ma.QueryEntityListByQuery(ctx, &relations, q); err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		if _, to := relationEnds(r, reverse); opts.allowSchema(to.schemaName) {
+			if _, ok := depths[to]; ok {
+				graph.Edges = append(graph.Edges, r)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// loadGraphEntities 按schema分组查询节点的entity
+func (ma *MetaAgent) loadGraphEntities(ctx context.Context, graph *Graph) error {
+	bySchema := map[string][]*GraphNode{}
+	for _, n := range graph.Nodes {
+		bySchema[n.SchemaName] = append(bySchema[n.SchemaName], n)
+	}
+	for schemaName, nodes := range bySchema {
+		ids := make([]interface{}, len(nodes))
+		for i, n := range nodes {
+			ids[i] = n.ID
+		}
+		q := ma.NewQuery(schemaName).Where(In("id", ids...))
+		var entities []interface{}
+		err := ma.IterateEntity(ctx, q, 0, func(mPtr interface{}) error {
+			entities = append(entities, mPtr)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		byID := make(map[int64]interface{}, len(entities))
+		for _, e := range entities {
+			if s, ok := e.(Schema); ok {
+				byID[s.GetID()] = e
+			}
+		}
+		for _, n := range nodes {
+			n.Entity = byID[n.ID]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// graphNodes 返回 名称:深度 列表
+func graphNodes(g *Graph) []string {
+	var nodes []string
+	for _, n := range g.Nodes {
+		switch e := n.Entity.(type) {
+		case *testUser:
+			nodes = append(nodes, fmt.Sprintf("%s:%d", e.Name, n.Depth))
+		case *testDoc:
+			nodes = append(nodes, fmt.Sprintf("%s:%d", e.Title, n.Depth))
+		default:
+			nodes = append(nodes, fmt.Sprintf("%s/%d:%d", n.SchemaName, n.ID, n.Depth))
+		}
+	}
+	return nodes
+}
+
+func graphEdges(g *Graph) []int64 {
+	var ids []int64
+	for _, r := range g.Edges {
+		ids = append(ids, r.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestMetaAgent_TraverseRelations(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	users := make([]*testUser, 5)
+	for i := range users {
+		users[i] = &testUser{Name: fmt.Sprintf("u%d", i)}
+		if err := ma.CreateEntity(ctx, users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := &testDoc{Title: "d0"}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	// u0 -> u1 -> u2 -> u0 成环，u2 -> u3 -follow-> u4，u0 -own-> d0
+	var relations []*EntityRelation
+	link := func(from *testUser, relationType string, toSchema string, toID int64) {
+		r := &EntityRelation{
+			SourceSchemaName: "test_user", SourceEntityID: from.ID,
+			TargetSchemaName: toSchema, TargetEntityID: toID, RelationType: relationType,
+		}
+		if err := ma.CreateRelation(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		relations = append(relations, r)
+	}
+	link(users[0], "manage", "test_user", users[1].ID)
+	link(users[1], "manage", "test_user", users[2].ID)
+	link(users[2], "manage", "test_user", users[0].ID)
+	link(users[2], "manage", "test_user", users[3].ID)
+	link(users[3], "follow", "test_user", users[4].ID)
+	link(users[0], "own", "test_doc", doc.ID)
+
+	cases := []struct {
+		name  string
+		ctx   context.Context
+		from  *testUser
+		opts  GraphOptions
+		nodes string
+		edges int
+	}{
+		{"default", ctx, users[0], GraphOptions{}, "[u0:0 u1:1 d0:1 u2:2 u3:3]", 5},
+		{"depth", ctx, users[0], GraphOptions{MaxDepth: 1}, "[u0:0 u1:1 d0:1]", 2},
+		{"type", ctx, users[0], GraphOptions{RelationTypes: []string{"manage"}}, "[u0:0 u1:1 u2:2 u3:3]", 4},
+		{"schema", ctx, users[0], GraphOptions{MaxDepth: 10, Schemas: []string{"test_user"}},
+			"[u0:0 u1:1 u2:2 u3:3 u4:4]", 5},
+		{"incoming", ctx, users[0], GraphOptions{Direction: GraphIncoming}, "[u0:0 u2:1 u1:2]", 3},
+		{"both", ctx, users[4], GraphOptions{Direction: GraphBoth, MaxDepth: 2}, "[u4:0 u3:1 u2:2]", 2},
+	}
+	for _, c := range cases {
+		for _, cte := range []bool{false, true} {
+			if cte && c.opts.Direction == GraphBoth {
+				continue
+			}
+			opts := c.opts
+			opts.UseCTE = cte
+			g, err := ma.TraverseRelations(c.ctx, "test_user", c.from.ID, &opts)
+			if err != nil {
+				t.Fatalf("%s cte=%v: %s", c.name, cte, err)
+			}
+			nodes := graphNodes(g)
+			if cte {
+				// CTE结果按深度排序，同一深度内的顺序与BFS不同
+				bfs, err := ma.TraverseRelations(c.ctx, "test_user", c.from.ID, &c.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := graphNodes(bfs)
+				sort.Strings(nodes)
+				sort.Strings(want)
+				if fmt.Sprint(nodes) != fmt.Sprint(want) || fmt.Sprint(graphEdges(g)) != fmt.Sprint(graphEdges(bfs)) {
+					t.Errorf("%s cte got %v %v, bfs got %v %v", c.name, nodes, graphEdges(g), want, graphEdges(bfs))
+				}
+				continue
+			}
+			if fmt.Sprint(nodes) != c.nodes || len(g.Edges) != c.edges {
+				t.Errorf("%s got %v, %d edges, want %s, %d edges", c.name, nodes, len(g.Edges), c.nodes, c.edges)
+			}
+		}
+	}
+
+	// 禁用的relation默认不经过
+	if err := ma.DisableRelation(ctx, relations[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, cte := range []bool{false, true} {
+		g, err := ma.TraverseRelations(ctx, "test_user", users[0].ID, &GraphOptions{UseCTE: cte})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Nodes) != 2 || len(g.Edges) != 1 {
+			t.Errorf("cte=%v got %v", cte, graphNodes(g))
+		}
+		g, err = ma.TraverseRelations(WithDisabledRelations(ctx), "test_user", users[0].ID, &GraphOptions{UseCTE: cte})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Nodes) != 5 {
+			t.Errorf("cte=%v with disabled got %v", cte, graphNodes(g))
+		}
+	}
+
+	for _, opts := range []*GraphOptions{
+		{MaxDepth: -1},
+		{Direction: "up"},
+		{Direction: GraphBoth, UseCTE: true},
+		{Schemas: []string{"not_exist"}},
+	} {
+		if _, err := ma.TraverseRelations(ctx, "test_user", users[0].ID, opts); err == nil {
+			t.Errorf("options %+v should fail", opts)
+		}
+	}
+	if _, err := ma.TraverseRelations(ctx, "test_user", 100, nil); err == nil {
+		t.Error("not exist entity should fail")
+	}
+
+	// http
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ma.RegisterGinHandler(router)
+	get := func(query string) (int, []string) {
+		w := httptest.NewRecorder()
+		path := fmt.Sprintf("/entity/test_user/by/id/%d/graph?%s", users[1].ID, query)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp struct {
+			Data struct {
+				Nodes []struct {
+					SchemaName string `json:"schema_name"`
+					Depth      int    `json:"depth"`
+				} `json:"nodes"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var nodes []string
+		for _, n := range resp.Data.Nodes {
+			nodes = append(nodes, fmt.Sprintf("%s:%d", n.SchemaName, n.Depth))
+		}
+		return w.Code, nodes
+	}
+	// u1 -> u2 -> u0, u3
+	code, nodes := get("max_depth=2&schemas=test_user&relation_types=manage&cte=true")
+	if code != http.StatusOK || fmt.Sprint(nodes) != "[test_user:0 test_user:1 test_user:2 test_user:2]" {
+		t.Errorf("got status %d, nodes %v", code, nodes)
+	}
+	for _, query := range []string{"max_depth=11", "direction=up", "schemas=not_exist"} {
+		if code, _ = get(query); code != http.StatusBadRequest {
+			t.Errorf("%s got status %d", query, code)
+		}
+	}
+}
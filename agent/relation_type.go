@@ -0,0 +1,135 @@
+package agent
+
+// relation类型注册，声明允许的(source schema, relation type, target schema)及其基数
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Cardinality relation的基数
+type Cardinality string
+
+const (
+	// 一个source最多关联一个target，一个target最多被一个source关联
+	RelationOneToOne Cardinality = "one_to_one"
+	// 一个source可以关联多个target，一个target最多被一个source关联
+	RelationOneToMany Cardinality = "one_to_many"
+	// 不限制
+	RelationManyToMany Cardinality = "many_to_many"
+)
+
+var (
+	ErrRelationNotAllowed  = errors.New("relation type not registered")
+	ErrRelationCardinality = errors.New("relation cardinality violated")
+	// CreateEntities、UpsertEntity不检查relation类型，EntityRelation需通过CreateRelation、UpsertRelation写入
+	ErrRelationBypass = errors.New("entity relation must be written by CreateRelation or UpsertRelation")
+)
+
+type relationTypeKey struct {
+	sourceSchema string
+	relationType string
+	targetSchema string
+}
+
+// RegisterRelationType 注册允许的relation类型，relationType可以为空串
+//	注册过任意relation类型后，CreateRelation、UpsertRelation只允许已注册的类型
+func (ma *MetaAgent) RegisterRelationType(sourceSchema, relationType, targetSchema string, cardinality Cardinality) {
+	if _, exist := ma.pool[sourceSchema]; !exist {
+		panic("schema not register: " + sourceSchema)
+	}
+	if _, exist := ma.pool[targetSchema]; !exist {
+		panic("schema not register: " + targetSchema)
+	}
+	switch cardinality {
+	case RelationOneToOne, RelationOneToMany, RelationManyToMany:
+	default:
+		panic("unknown cardinality: " + cardinality)
+	}
+	if ma.relationTypes == nil {
+		ma.relationTypes = map[relationTypeKey]Cardinality{}
+	}
+	ma.relationTypes[relationTypeKey{sourceSchema, relationType, targetSchema}] = cardinality
+}
+
+// GetRelationCardinality 返回relation类型的基数，未注册时返回false
+func (ma *MetaAgent) GetRelationCardinality(sourceSchema, relationType, targetSchema string) (Cardinality, bool) {
+	cardinality, exist := ma.relationTypes[relationTypeKey{sourceSchema, relationType, targetSchema}]
+	return cardinality, exist
+}
+
+// checkRelationType 检查relation类型是否已注册、是否满足基数，没有注册任何relation类型时不检查
+//	与relation本身uuid相同的记录不视为冲突，以支持upsert
+//	必须在事务中调用，计数前锁住基数受限一端的entity，并发创建的relation在此排队，不会同时通过检查
+func (ma *MetaAgent) checkRelationType(ctx context.Context, r *EntityRelation) error {
+	if len(ma.relationTypes) == 0 {
+		return nil
+	}
+	cardinality, exist := ma.GetRelationCardinality(r.SourceSchemaName, r.RelationType, r.TargetSchemaName)
+	if !exist {
+		return fmt.Errorf("%w: %s -[%s]-> %s", ErrRelationNotAllowed,
+			r.SourceSchemaName, r.RelationType, r.TargetSchemaName)
+	}
+	if cardinality == RelationManyToMany {
+		return nil
+	}
+	if err := ma.lockCardinalityEndpoints(ctx, r, cardinality); err != nil {
+		return err
+	}
+
+	db := ma.GetDB(ctx).Model(&EntityRelation{}).Where(
+		"source_schema_name=? and relation_type=? and target_schema_name=?",
+		r.SourceSchemaName, r.RelationType, r.TargetSchemaName)
+	var count int
+	// target已被其他source关联
+	err := db.Where("target_entity_id=? and source_entity_id<>?", r.TargetEntityID, r.SourceEntityID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s %d already has a %s source", ErrRelationCardinality,
+			r.TargetSchemaName, r.TargetEntityID, r.RelationType)
+	}
+	if cardinality == RelationOneToMany {
+		return nil
+	}
+	// source已关联其他target
+	err = db.Where("source_entity_id=? and target_entity_id<>?", r.SourceEntityID, r.TargetEntityID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s %d already has a %s target", ErrRelationCardinality,
+			r.SourceSchemaName, r.SourceEntityID, r.RelationType)
+	}
+	return nil
+}
+
+// lockCardinalityEndpoints 锁住基数受限一端的entity，one_to_many锁target，one_to_one锁source与target
+//	按schema名、id升序加锁，避免不同事务加锁顺序不一致导致死锁
+//	sql like:
+//		select * from {schema_name}
+//		where id in (?)
+//		order by id
+//		for update
+func (ma *MetaAgent) lockCardinalityEndpoints(ctx context.Context, r *EntityRelation, cardinality Cardinality) error {
+	ids := map[string][]int64{r.TargetSchemaName: {r.TargetEntityID}}
+	if cardinality == RelationOneToOne {
+		ids[r.SourceSchemaName] = append(ids[r.SourceSchemaName], r.SourceEntityID)
+	}
+	schemas := make([]string, 0, len(ids))
+	for schemaName := range ids {
+		schemas = append(schemas, schemaName)
+	}
+	sort.Strings(schemas)
+	for _, schemaName := range schemas {
+		if _, err := ma.LockEntitiesByID(ctx, schemaName, ids[schemaName], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMetaAgent_RelationType(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	users := []*testUser{{Name: "u1"}, {Name: "u2"}}
+	for _, u := range users {
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docs := []*testDoc{{Title: "d1"}, {Title: "d2"}}
+	for _, d := range docs {
+		if err := ma.CreateEntity(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	relation := func(user *testUser, relationType string, doc *testDoc) *EntityRelation {
+		return &EntityRelation{
+			SourceSchemaName: "test_user", SourceEntityID: user.ID,
+			TargetSchemaName: "test_doc", TargetEntityID: doc.ID,
+			RelationType: relationType,
+		}
+	}
+
+	// 未注册relation类型时不检查，同一对entity可以有不同类型的relation
+	for _, r := range []*EntityRelation{relation(users[0], "", docs[0]), relation(users[0], "follow", docs[0])} {
+		if err := ma.CreateRelation(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ma.CreateRelation(ctx, relation(users[0], "follow", docs[0])); err == nil {
+		t.Error("duplicate relation should fail")
+	}
+
+	ma.RegisterRelationType("test_user", "own", "test_doc", RelationOneToMany)
+	ma.RegisterRelationType("test_user", "follow", "test_doc", RelationManyToMany)
+	ma.RegisterRelationType("test_user", "edit", "test_doc", RelationOneToOne)
+	if c, ok := ma.GetRelationCardinality("test_user", "own", "test_doc"); !ok || c != RelationOneToMany {
+		t.Errorf("got cardinality %s, %v", c, ok)
+	}
+
+	cases := []struct {
+		relation *EntityRelation
+		err      error
+	}{
+		{relation(users[0], "like", docs[0]), ErrRelationNotAllowed},
+		{relation(users[0], "own", docs[0]), nil},
+		{relation(users[0], "own", docs[1]), nil},
+		// doc已经有owner
+		{relation(users[1], "own", docs[0]), ErrRelationCardinality},
+		{relation(users[1], "follow", docs[0]), nil},
+		{relation(users[0], "edit", docs[0]), nil},
+		// user已经在编辑doc
+		{relation(users[0], "edit", docs[1]), ErrRelationCardinality},
+		// doc已经有编辑者
+		{relation(users[1], "edit", docs[0]), ErrRelationCardinality},
+	}
+	for _, c := range cases {
+		err := ma.CreateRelation(ctx, c.relation)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s %d -> %d got %v, want %v", c.relation.RelationType,
+				c.relation.SourceEntityID, c.relation.TargetEntityID, err, c.err)
+		}
+	}
+
+	// 基数检查前锁住entity，只能在事务中执行
+	if err := ma.checkRelationType(ctx, relation(users[1], "own", docs[1])); !errors.Is(err, ErrNotInTransaction) {
+		t.Errorf("check outside tx got %v", err)
+	}
+
+	// upsert已存在的relation不违反基数
+	r := relation(users[0], "edit", docs[0])
+	r.Content = "new"
+	if err := ma.UpsertRelation(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ma.QueryRelationByUuid(ctx, relation(users[0], "edit", docs[0]))
+	if err != nil || got.Content != "new" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
+
+func TestMetaAgent_QueryRelationByUuid_EmptyType(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	users := []*testUser{{Name: "u1"}, {Name: "u2"}}
+	for _, u := range users {
+		if err := ma.CreateEntity(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	relation := func(relationType string) *EntityRelation {
+		return &EntityRelation{
+			SourceSchemaName: "test_user", SourceEntityID: users[0].ID,
+			TargetSchemaName: "test_user", TargetEntityID: users[1].ID,
+			RelationType: relationType,
+		}
+	}
+	for _, r := range []*EntityRelation{relation(""), relation("follow")} {
+		if err := ma.CreateRelation(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// relation_type为空时只匹配无类型的relation
+	r := relation("")
+	r.Content = "untyped"
+	if err := ma.UpdateRelationContentByID(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.DisableRelation(ctx, relation("")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ma.QueryRelationByUuid(ctx, relation(""))
+	if err != nil || got.Content != "untyped" || got.Status != EntityRelationStatusDISABLE {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	got, err = ma.QueryRelationByUuid(ctx, relation("follow"))
+	if err != nil || got.Content != "" || got.Status != EntityRelationStatusENABLE {
+		t.Errorf("typed relation should not change, got %+v, %v", got, err)
+	}
+
+	if err = ma.DeleteRelation(ctx, relation("")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ma.QueryRelationByUuid(ctx, relation("")); err == nil {
+		t.Error("untyped relation should be deleted")
+	}
+	if _, err = ma.QueryRelationByUuid(ctx, relation("follow")); err != nil {
+		t.Errorf("typed relation should not be deleted: %v", err)
+	}
+}
+
+func TestMetaAgent_RelationBypass(t *testing.T) {
+	ma := newTestAgent(t, new(testDoc))
+	ctx := context.Background()
+	user, doc := &testUser{Name: "u1"}, &testDoc{Title: "d1"}
+	if err := ma.CreateEntity(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.CreateEntity(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	ma.RegisterRelationType("test_user", "own", "test_doc", RelationOneToMany)
+	relation := &EntityRelation{
+		SourceSchemaName: "test_user", SourceEntityID: user.ID,
+		TargetSchemaName: "test_doc", TargetEntityID: doc.ID,
+		RelationType: "like",
+	}
+
+	// 批量插入、upsert不检查relation类型，直接拒绝
+	relations := []*EntityRelation{relation}
+	if err := ma.CreateEntities(ctx, &relations, 0); !errors.Is(err, ErrRelationBypass) {
+		t.Errorf("CreateEntities got %v", err)
+	}
+	opts := &UpsertOptions{ConflictColumns: relationUuidColumns}
+	if err := ma.UpsertEntity(ctx, relation, opts); !errors.Is(err, ErrRelationBypass) {
+		t.Errorf("UpsertEntity got %v", err)
+	}
+	if err := ma.UpsertRelation(ctx, relation); !errors.Is(err, ErrRelationNotAllowed) {
+		t.Errorf("UpsertRelation got %v", err)
+	}
+	relation.RelationType = "own"
+	if err := ma.UpsertRelation(ctx, relation); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+// 读写分离: 读请求按权重轮询路由到从库，写请求和事务内的读请求始终使用主库
+
+import (
+	"context"
+	"github.com/jinzhu/gorm"
+	"sync/atomic"
+)
+
+type primaryKey struct{}
+
+type replicaSet struct {
+	dbs []*gorm.DB
+	// 按权重展开的从库下标，轮询时从中取值
+	slots []int
+	next  uint32
+}
+
+func (rs *replicaSet) add(db *gorm.DB, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	rs.dbs = append(rs.dbs, db)
+	for i := 0; i < weight; i++ {
+		rs.slots = append(rs.slots, len(rs.dbs)-1)
+	}
+}
+
+func (rs *replicaSet) pick() *gorm.DB {
+	if len(rs.slots) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(&rs.next, 1)
+	return rs.dbs[rs.slots[(n-1)%uint32(len(rs.slots))]]
+}
+
+// RegisterReplica 注册一个只读从库，weight为读流量权重，<=0视为1
+//	配合orm.Config使用:
+//		dbs, err := c.OpenMysqlReplicas()
+//		for i, db := range dbs {
+//			ma.RegisterReplica(db, c.Replicas[i].Weight)
+//		}
+func (ma *MetaAgent) RegisterReplica(db *gorm.DB, weight int) {
+	ma.replicas.add(db, weight)
+}
+
+// WithPrimary 返回强制读主库的ctx，用于写后立即读的场景
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(primaryKey{}).(bool)
+	return force
+}
+
+// GetReadDB 获取读连接
+//	1. ctx中有事务连接则使用事务连接
+//	2. ctx通过WithPrimary要求读主库或没有注册从库则使用主库
+//	3. 否则按权重轮询选择一个从库
+func (ma *MetaAgent) GetReadDB(ctx context.Context) *gorm.DB {
+	if tx, err := ma.getTxConnFromContext(ctx); err == nil {
+		return tx
+	}
+	if isForcePrimary(ctx) {
+		return ma.db
+	}
+	if db := ma.replicas.pick(); db != nil {
+		return db
+	}
+	return ma.db
+}
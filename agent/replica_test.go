@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"github.com/jinzhu/gorm"
+	"github.com/lucky-loki/orm"
+	"testing"
+)
+
+func TestMetaAgent_GetReadDB(t *testing.T) {
+	ma := newTestAgent(t)
+	c := &orm.Config{Database: t.Name() + "_replica"}
+	replica, err := c.OpenSqlite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if err = replica.AutoMigrate(new(testUser)).Error; err != nil {
+		t.Fatal(err)
+	}
+	ma.RegisterReplica(replica, 1)
+
+	ctx := context.Background()
+	if err = ma.CreateEntity(ctx, &testUser{Name: "primary"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = replica.Create(&testUser{Name: "replica"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var u testUser
+	if err = ma.QueryOneEntityByStringFilter(ctx, &u, "id=?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "replica" {
+		t.Errorf("read should go to replica, got %s", u.Name)
+	}
+
+	u = testUser{}
+	if err = ma.QueryOneEntityByStringFilter(WithPrimary(ctx), &u, "id=?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "primary" {
+		t.Errorf("WithPrimary read should go to primary, got %s", u.Name)
+	}
+
+	err = ma.WithTransaction(ctx, func(ctx context.Context) error {
+		u = testUser{}
+		return ma.QueryOneEntityByStringFilter(ctx, &u, "id=?", 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "primary" {
+		t.Errorf("read in tx should go to primary, got %s", u.Name)
+	}
+}
+
+func TestReplicaSet_pick(t *testing.T) {
+	var rs replicaSet
+	if rs.pick() != nil {
+		t.Fatal("empty replica set should pick nil")
+	}
+	a, b := new(gorm.DB), new(gorm.DB)
+	rs.add(a, 3)
+	rs.add(b, 0)
+	count := map[*gorm.DB]int{}
+	for i := 0; i < 8; i++ {
+		count[rs.pick()]++
+	}
+	if count[a] != 6 || count[b] != 2 {
+		t.Errorf("weighted pick not balanced: a=%d b=%d", count[a], count[b])
+	}
+}
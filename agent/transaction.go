@@ -4,84 +4,165 @@ package agent
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
-	"sort"
+	"time"
 )
 
 type txKey struct{}
 
+// txContext 保存在ctx中的事务状态
+type txContext struct {
+	tx *gorm.DB
+	// 嵌套深度，用于生成savepoint名
+	depth int
+	// 提交、回滚后执行的回调
+	hooks txHooks
+}
+
 // 实现的txHandler将一组ddl函数组合起来，返回值表示这一组ddl执行是否成功
 // 可以通过 `GetTxConnFromContext` 从ctx中获取已经开启事务的连接
 type txHandler func(ctx context.Context) error
 
 // 从ctx获取已经开启事务的连接
 func (ma *MetaAgent) getTxConnFromContext(ctx context.Context) (*gorm.DB, error) {
-	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
-		return tx, nil
+	if tc, ok := ctx.Value(txKey{}).(*txContext); ok {
+		return tc.tx, nil
 	}
 	return nil, errors.New("tx conn not found")
 }
 
-// 包装事务，可以更优雅的组织事务代码，支持mini-tx
+// 包装事务，可以更优雅的组织事务代码，支持通过savepoint嵌套的mini-tx
 func (ma *MetaAgent) WithTransaction(ctx context.Context, scopeDDLs txHandler) (err error) {
 	// 功能: 包装事务，dao层不需要关系使用的db conn是否是开启事务的，从而可以更加优雅的组织dao的代码
-	//		1. 从ctx获取事务连接，如果没有则主动开启事务并将parent设置为true
+	//		1. 从ctx获取事务，如果没有则主动开启事务作为parent，
+	//		   否则在已有事务上创建savepoint作为mini-tx
 	//		2. 执行由ddlsFunc封装的一组ddl
-	// 		3. 如果ddlsFunc panic或者返回err，那么事务将执行回滚
-	//		4. 如果ddlsFunc返回nil，就认为这个mini-tx执行成功
+	// 		3. 如果ddlsFunc panic或者返回err，parent回滚整个事务，mini-tx只回滚到自己的savepoint，
+	//		   调用方可以处理mini-tx的错误后继续执行外层事务
+	//		4. 如果ddlsFunc返回nil，就认为这个mini-tx执行成功，释放savepoint
 	//		5. 最后由parent提交事务
+	if parentTc, ok := ctx.Value(txKey{}).(*txContext); ok {
+		return ma.withSavepoint(ctx, parentTc, scopeDDLs)
+	}
+	return ma.withTransaction(ctx, nil, scopeDDLs)
+}
+
+// TxOptions 事务选项
+type TxOptions struct {
+	// 隔离级别，默认使用数据库的默认隔离级别
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// 事务超时时间，到期后事务自动回滚；为0时只使用ctx自带的deadline
+	// 开启重试时每次执行单独计时
+	Timeout time.Duration
+	// 死锁、序列化失败时重试整个事务，nil表示不重试
+	Retry *RetryPolicy
+}
+
+// WithTransactionOptions 按指定的隔离级别、只读、超时开启事务，其余行为同WithTransaction
+//	事务与ctx绑定，ctx取消或超时后事务自动回滚
+//	如果ctx中已有事务，则以savepoint执行mini-tx，opts被忽略
+//	开启重试时scopeDDLs可能被执行多次，不要在其中产生事务之外的副作用
+func (ma *MetaAgent) WithTransactionOptions(ctx context.Context, opts *TxOptions, scopeDDLs txHandler) (err error) {
+	if parentTc, ok := ctx.Value(txKey{}).(*txContext); ok {
+		return ma.withSavepoint(ctx, parentTc, scopeDDLs)
+	}
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	attempt := func() error {
+		txCtx := ctx
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			txCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+		return ma.withTransaction(txCtx, opts, scopeDDLs)
+	}
+	if opts.Retry == nil {
+		return attempt()
+	}
+	return opts.Retry.run(ctx, attempt)
+}
 
-	// 获取事务连接，如果没有则开启事务
+// withTransaction 开启事务并执行scopeDDLs，opts为nil时开启的事务不与ctx绑定
+func (ma *MetaAgent) withTransaction(ctx context.Context, opts *TxOptions, scopeDDLs txHandler) (err error) {
+	// 开启事务
 	var tx *gorm.DB
-	var parent bool // 事务由parent提交
-	tx, err = ma.getTxConnFromContext(ctx)
-	if err != nil {
+	if opts == nil {
 		tx = ma.db.Begin()
-		parent = true
+	} else {
+		tx = ma.db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
 	}
-
 	err = tx.Error
 	if err != nil {
 		return
 	}
+	tc := &txContext{tx: tx}
 	defer func() {
 		// scopeDDLFunc触发panic，回滚
 		if p := recover(); p != nil {
 			tx.Rollback()
 			err = fmt.Errorf("tx panic: %v", p)
+			tc.hooks.runRollback(ctx)
 			return
 		}
 		// scopeDDLFunc执行出错，回滚
 		if err != nil {
 			tx.Rollback()
-			return
-		}
-		// 非parent直接退出不提交
-		if !parent {
+			tc.hooks.runRollback(ctx)
 			return
 		}
 		// 提交
 		err = tx.Commit().Error
 		if err != nil {
+			tc.hooks.runRollback(ctx)
 			return
 		}
+		tc.hooks.runCommit(ctx)
 	}()
-	newCtx := context.WithValue(ctx, txKey{}, tx)
+	newCtx := context.WithValue(ctx, txKey{}, tc)
 	// 执行一组ddl，如果返回nil视为这组ddl表示的mini-tx执行成功准备提交
 	err = scopeDDLs(newCtx)
 	return
 }
 
-func (ma *MetaAgent) LockRecordByID(ctx context.Context, schema string, ids []int64) error {
-	db := ma.GetDB(ctx)
-	intIds := make([]int, len(ids))
-	for i, id := range ids {
-		intIds[i] = int(id)
+// withSavepoint 在已开启的事务上以savepoint执行mini-tx，出错时只回滚到该savepoint
+func (ma *MetaAgent) withSavepoint(ctx context.Context, parent *txContext, scopeDDLs txHandler) (err error) {
+	tc := &txContext{tx: parent.tx, depth: parent.depth + 1}
+	savepoint := fmt.Sprintf("sp_%d", tc.depth)
+	if err = tc.tx.Exec("SAVEPOINT " + savepoint).Error; err != nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = panicError(p)
+		}
+		if err != nil {
+			if rbErr := tc.tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint).Error; rbErr != nil {
+				// 保留原始错误，死锁等错误回滚了整个事务时仍可被IsRetryableTxError、errors.Is识别
+				err = fmt.Errorf("%w, rollback to savepoint failed: %v", err, rbErr)
+			}
+			parent.hooks.mergeRollback(&tc.hooks)
+			return
+		}
+		if err = tc.tx.Exec("RELEASE SAVEPOINT " + savepoint).Error; err != nil {
+			parent.hooks.mergeRollback(&tc.hooks)
+			return
+		}
+		parent.hooks.merge(&tc.hooks)
+	}()
+	err = scopeDDLs(context.WithValue(ctx, txKey{}, tc))
+	return
+}
+
+// panicError 将mini-tx的panic转为error，panic值为error时保留以便errors.Is、errors.As识别
+func panicError(p interface{}) error {
+	if err, ok := p.(error); ok {
+		return fmt.Errorf("tx panic: %w", err)
 	}
-	sort.Ints(intIds)
-	sql := "select * from " + schema + " where id in (?) for update"
-	err :=  db.Exec(sql, ids).Error
-	return err
-}
\ No newline at end of file
+	return fmt.Errorf("tx panic: %v", p)
+}
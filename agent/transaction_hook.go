@@ -0,0 +1,94 @@
+package agent
+
+// 事务提交、回滚后的回调，用于发送消息、清理缓存等只应在事务真正结束后执行的操作
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// TxHook 事务结束后执行的回调，ctx为调用WithTransaction时传入的ctx
+type TxHook func(ctx context.Context)
+
+type txHooks struct {
+	mu       sync.Mutex
+	commit   []TxHook
+	rollback []TxHook
+	// 已回滚到savepoint的mini-tx注册的回滚回调，无论事务最终提交还是回滚都会执行
+	rolledBack []TxHook
+}
+
+// merge mini-tx成功后，其回调交给parent在事务结束后执行
+func (h *txHooks) merge(child *txHooks) {
+	h.mu.Lock()
+	h.commit = append(h.commit, child.commit...)
+	h.rollback = append(h.rollback, child.rollback...)
+	h.rolledBack = append(h.rolledBack, child.rolledBack...)
+	h.mu.Unlock()
+}
+
+// mergeRollback mini-tx回滚到savepoint后，其提交回调作废，回滚回调交给parent在事务结束后执行
+func (h *txHooks) mergeRollback(child *txHooks) {
+	h.mu.Lock()
+	h.rolledBack = append(h.rolledBack, child.rolledBack...)
+	h.rolledBack = append(h.rolledBack, child.rollback...)
+	h.mu.Unlock()
+}
+
+func (h *txHooks) runCommit(ctx context.Context) {
+	h.mu.Lock()
+	rolledBack, commit := h.rolledBack, h.commit
+	h.mu.Unlock()
+	runHooks(ctx, rolledBack)
+	runHooks(ctx, commit)
+}
+
+func (h *txHooks) runRollback(ctx context.Context) {
+	h.mu.Lock()
+	rolledBack, rollback := h.rolledBack, h.rollback
+	h.mu.Unlock()
+	runHooks(ctx, rolledBack)
+	runHooks(ctx, rollback)
+}
+
+// runHooks 按注册顺序执行回调，单个回调panic不影响其余回调
+func runHooks(ctx context.Context, hooks []TxHook) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("tx hook panic: %v", p)
+				}
+			}()
+			hook(ctx)
+		}()
+	}
+}
+
+// OnCommit 注册最外层事务提交成功后执行的回调
+//	ctx须为txHandler收到的ctx，不在事务中时立即执行
+//	mini-tx回滚到savepoint后，其中注册的回调不会执行
+func OnCommit(ctx context.Context, hook TxHook) {
+	tc, ok := ctx.Value(txKey{}).(*txContext)
+	if !ok {
+		runHooks(ctx, []TxHook{hook})
+		return
+	}
+	tc.hooks.mu.Lock()
+	tc.hooks.commit = append(tc.hooks.commit, hook)
+	tc.hooks.mu.Unlock()
+}
+
+// OnRollback 注册事务回滚后执行的回调
+//	ctx须为txHandler收到的ctx，不在事务中时不会执行
+//	mini-tx中注册的回调在其回滚到savepoint或最外层事务回滚后，由最外层事务结束时执行
+func OnRollback(ctx context.Context, hook TxHook) {
+	tc, ok := ctx.Value(txKey{}).(*txContext)
+	if !ok {
+		return
+	}
+	tc.hooks.mu.Lock()
+	tc.hooks.rollback = append(tc.hooks.rollback, hook)
+	tc.hooks.mu.Unlock()
+}
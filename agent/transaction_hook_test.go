@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOnCommit(t *testing.T) {
+	ma := newTestAgent(t)
+	var events []string
+	record := func(e string) TxHook {
+		return func(ctx context.Context) { events = append(events, e) }
+	}
+
+	err := ma.WithTransaction(context.Background(), func(ctx context.Context) error {
+		OnCommit(ctx, record("outer commit"))
+		OnRollback(ctx, record("outer rollback"))
+		_ = ma.WithTransaction(ctx, func(ctx context.Context) error {
+			OnCommit(ctx, record("failed commit"))
+			OnRollback(ctx, record("failed rollback"))
+			return errors.New("inner failed")
+		})
+		err := ma.WithTransaction(ctx, func(ctx context.Context) error {
+			OnCommit(ctx, record("inner commit"))
+			return nil
+		})
+		if len(events) != 0 {
+			t.Errorf("hooks should be deferred to parent, got %v", events)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"failed rollback", "outer commit", "inner commit"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+
+	events = nil
+	_ = ma.WithTransaction(context.Background(), func(ctx context.Context) error {
+		OnCommit(ctx, record("commit"))
+		OnRollback(ctx, record("rollback"))
+		return errors.New("failed")
+	})
+	if !reflect.DeepEqual(events, []string{"rollback"}) {
+		t.Errorf("got %v, want [rollback]", events)
+	}
+
+	events = nil
+	OnCommit(context.Background(), record("no tx"))
+	if !reflect.DeepEqual(events, []string{"no tx"}) {
+		t.Errorf("OnCommit outside tx should run immediately, got %v", events)
+	}
+}
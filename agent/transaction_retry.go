@@ -0,0 +1,108 @@
+package agent
+
+// 事务在死锁、序列化失败时的自动重试
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 10 * time.Millisecond
+	defaultRetryMaxDelay  = time.Second
+)
+
+// RetryPolicy 事务重试策略，只作用于最外层事务
+type RetryPolicy struct {
+	// 最大执行次数(包含第一次)，<=1表示不重试
+	MaxAttempts int
+	// 第n次重试前等待[d/2, d)的随机时间，d = min(BaseDelay * 2^(n-1), MaxDelay)
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// 判断错误是否可重试，默认为IsRetryableTxError
+	Retryable func(err error) bool
+}
+
+// RetryExhaustedError 可重试错误在重试MaxAttempts次后仍然失败
+type RetryExhaustedError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf("tx failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryableTxError 判断是否为可通过重试整个事务解决的错误
+//	mysql: 1213 deadlock, 1205 lock wait timeout
+//	postgres: 40001 serialization_failure, 40P01 deadlock_detected
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryableTxError(err)
+}
+
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+	d := base
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// run 执行fn，遇到可重试错误时按退避策略重新执行，重试耗尽时返回RetryExhaustedError
+func (p *RetryPolicy) run(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !p.retryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			// 没有发生重试时返回原始错误，与重试耗尽区分
+			if attempt == 1 {
+				return err
+			}
+			return &RetryExhaustedError{Attempts: attempt, Err: err}
+		}
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	cases := map[error]bool{
+		&mysql.MySQLError{Number: 1213}:                  true,
+		fmt.Errorf("wrap: %w", &pq.Error{Code: "40001"}): true,
+		&pq.Error{Code: "40P01"}:                         true,
+		&mysql.MySQLError{Number: 1062}:                  false,
+		errors.New("record not found"):                   false,
+	}
+	for err, want := range cases {
+		if got := IsRetryableTxError(err); got != want {
+			t.Errorf("IsRetryableTxError(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestMetaAgent_WithTransactionOptions_Retry(t *testing.T) {
+	ma := newTestAgent(t)
+	ctx := context.Background()
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+
+	attempts := 0
+	err := ma.WithTransactionOptions(ctx, &TxOptions{Retry: policy}, func(ctx context.Context) error {
+		attempts++
+		if err := ma.CreateEntity(ctx, &testUser{Name: "retry"}); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts %d, want 3", attempts)
+	}
+	if got := countUsers(t, ma, "retry"); got != 1 {
+		t.Errorf("only the last attempt should be committed, got %d", got)
+	}
+
+	attempts = 0
+	err = ma.WithTransactionOptions(ctx, &TxOptions{Retry: policy}, func(ctx context.Context) error {
+		attempts++
+		return deadlock
+	})
+	var exhausted *RetryExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Attempts != 3 || !errors.Is(err, deadlock) {
+		t.Errorf("got err %v, want RetryExhaustedError after 3 attempts", err)
+	}
+
+	attempts = 0
+	errNormal := errors.New("normal")
+	err = ma.WithTransactionOptions(ctx, &TxOptions{Retry: policy}, func(ctx context.Context) error {
+		attempts++
+		return errNormal
+	})
+	if err != errNormal || attempts != 1 {
+		t.Errorf("normal err should not be retried, got %v after %d attempts", err, attempts)
+	}
+
+	// MaxAttempts<=1不重试，返回原始错误
+	err = ma.WithTransactionOptions(ctx, &TxOptions{Retry: &RetryPolicy{MaxAttempts: 1}}, func(ctx context.Context) error {
+		return deadlock
+	})
+	if err != deadlock {
+		t.Errorf("no retry should return raw err, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func countUsers(t *testing.T, ma *MetaAgent, name string) int {
+	var total int
+	err := ma.GetDB(context.Background()).Model(&testUser{}).Where("name=?", name).Count(&total).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestMetaAgent_WithTransaction_Savepoint(t *testing.T) {
+	ma := newTestAgent(t)
+	errInner := errors.New("inner failed")
+
+	err := ma.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := ma.CreateEntity(ctx, &testUser{Name: "outer"}); err != nil {
+			return err
+		}
+		err := ma.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := ma.CreateEntity(ctx, &testUser{Name: "inner"}); err != nil {
+				return err
+			}
+			return errInner
+		})
+		if err != errInner {
+			t.Errorf("got inner err %v", err)
+		}
+		err = ma.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := ma.CreateEntity(ctx, &testUser{Name: "panic"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+		if err == nil {
+			t.Error("inner panic should return err")
+		}
+		return ma.WithTransaction(ctx, func(ctx context.Context) error {
+			return ma.CreateEntity(ctx, &testUser{Name: "sibling"})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int{"outer": 1, "inner": 0, "panic": 0, "sibling": 1} {
+		if got := countUsers(t, ma, name); got != want {
+			t.Errorf("user %s count %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestMetaAgent_WithTransaction_SavepointWrapErr(t *testing.T) {
+	ma := newTestAgent(t)
+	err := ma.WithTransaction(context.Background(), func(ctx context.Context) error {
+		// 模拟死锁回滚整个事务后savepoint已不存在，回滚到savepoint失败时仍保留原始错误
+		err := ma.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := ma.GetDB(ctx).Exec("RELEASE SAVEPOINT sp_1").Error; err != nil {
+				return err
+			}
+			return ErrVersionConflict
+		})
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("got inner err %v", err)
+		}
+		err = ma.WithTransaction(ctx, func(ctx context.Context) error {
+			panic(ErrVersionConflict)
+		})
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("got inner panic err %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetaAgent_WithTransaction_Rollback(t *testing.T) {
+	ma := newTestAgent(t)
+	err := ma.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := ma.CreateEntity(ctx, &testUser{Name: "outer"}); err != nil {
+			return err
+		}
+		return ma.WithTransaction(ctx, func(ctx context.Context) error {
+			return errors.New("inner failed")
+		})
+	})
+	if err == nil {
+		t.Fatal("propagated inner err should fail the tx")
+	}
+	if got := countUsers(t, ma, "outer"); got != 0 {
+		t.Errorf("outer tx should be rolled back, got %d", got)
+	}
+}
+
+func TestMetaAgent_WithTransactionOptions_Timeout(t *testing.T) {
+	ma := newTestAgent(t)
+	opts := &TxOptions{Isolation: sql.LevelSerializable, Timeout: 10 * time.Millisecond}
+	err := ma.WithTransactionOptions(context.Background(), opts, func(ctx context.Context) error {
+		if err := ma.CreateEntity(ctx, &testUser{Name: "timeout"}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+	if err == nil {
+		t.Fatal("tx should fail after timeout")
+	}
+	if got := countUsers(t, ma, "timeout"); got != 0 {
+		t.Errorf("timeout tx should be rolled back, got %d", got)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import "reflect"
+
+// FieldByTag 按tag值查找结构体字段，规则与SetValueByTag一致:
+//	tag为空时使用字段名，tag为"-"的字段忽略，匿名嵌入的结构体会展开查找，
+//	返回字段的Index为相对最外层结构体的完整路径，可直接用于FieldByIndex
+func FieldByTag(ptr interface{}, tagValue string, tag string) (reflect.StructField, bool) {
+	t := reflect.TypeOf(ptr)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return emptyField, false
+	}
+	return fieldByTag(t, tagValue, tag)
+}
+
+func fieldByTag(t reflect.Type, tagValue string, tag string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous { // unexported
+			continue
+		}
+		tv := sf.Tag.Get(tag)
+		if tv == "-" {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && tv == "" {
+			if f, ok := fieldByTag(sf.Type, tagValue, tag); ok {
+				f.Index = append([]int{i}, f.Index...)
+				return f, true
+			}
+			continue
+		}
+		name, _ := head(tv, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		if name == tagValue {
+			return sf, true
+		}
+	}
+	return emptyField, false
+}
@@ -15,3 +15,28 @@ func TestSetValueByTag(t *testing.T) {
 	}
 	t.Log(s)
 }
+
+type embedStruct struct {
+	ID int64 `json:"id"`
+}
+
+type fieldStruct struct {
+	embedStruct
+	Name    string `json:"name,omitempty"`
+	Ignored string `json:"-"`
+	NoTag   int
+}
+
+func TestFieldByTag(t *testing.T) {
+	cases := map[string]string{"id": "ID", "name": "Name", "NoTag": "NoTag", "-": "", "Ignored": ""}
+	for tagValue, want := range cases {
+		f, ok := FieldByTag(&fieldStruct{}, tagValue, "json")
+		if ok != (want != "") || f.Name != want {
+			t.Errorf("FieldByTag(%s) got %s, %v, want %s", tagValue, f.Name, ok, want)
+		}
+	}
+	f, _ := FieldByTag(&fieldStruct{}, "id", "json")
+	if len(f.Index) != 2 {
+		t.Errorf("embedded field index should be full path, got %v", f.Index)
+	}
+}
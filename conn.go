@@ -1,10 +1,19 @@
 package orm
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/mattn/go-sqlite3"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,21 +21,74 @@ const (
 	defaultMaxIdleConns    = 10
 	defaultMaxOpenConns    = 100
 	defaultConnMaxLifeTime = 10 * time.Hour
+
+	defaultConnectRetryInterval    = time.Second
+	defaultConnectRetryMaxInterval = 30 * time.Second
+
+	defaultSqliteMemoryName = "orm"
+)
+
+// supported dialects
+const (
+	DialectMysql    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSqlite   = "sqlite3"
+)
+
+var (
+	defaultMysqlParams = map[string]string{"charset": "utf8", "parseTime": "True", "loc": "Local"}
+	defaultPgParams    = map[string]string{"sslmode": "disable"}
 )
 
 // db config
 type Config struct {
+	// mysql, postgres or sqlite3, only used by Open
+	Dialect string `yaml:"dialect"`
+
 	// db server config
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Database string
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+
+	// extra dsn params, override the defaults of each dialect,
+	// e.g. charset=utf8mb4, tls=true, loc=UTC for mysql
+	// or sslmode=require, TimeZone=UTC, connect_timeout=5 for postgres
+	Params map[string]string `yaml:"params"`
+
+	// sqlite db file path, empty means in-memory db named by Database
+	Path string `yaml:"path"`
+
+	// read replica servers, share User/Password/Database with primary
+	Replicas []ReplicaConfig `yaml:"replicas"`
 
 	// connection pool config
-	MaxIdleConns    int
-	MaxOpenConns    int
-	ConnMaxLifeTime time.Duration
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	ConnMaxLifeTime time.Duration `yaml:"conn_max_life_time"`
+
+	// connect retry config, the interval doubles after each failed attempt
+	// until ConnectRetryMaxInterval, 0 ConnectRetries means no retry
+	ConnectRetries          int           `yaml:"connect_retries"`
+	ConnectRetryInterval    time.Duration `yaml:"connect_retry_interval"`
+	ConnectRetryMaxInterval time.Duration `yaml:"connect_retry_max_interval"`
+
+	// logger of connect retries, nil means no log
+	Logger Logger `yaml:"-"`
+}
+
+// Logger 连接重试等日志的输出，*log.Logger即满足该接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// read replica server config
+type ReplicaConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// weight of read traffic, 0 means 1
+	Weight int `yaml:"weight"`
 }
 
 // check conn pool params
@@ -40,16 +102,71 @@ func (c *Config) check() {
 	if c.ConnMaxLifeTime == 0 {
 		c.ConnMaxLifeTime = defaultConnMaxLifeTime
 	}
+	if c.ConnectRetryInterval == 0 {
+		c.ConnectRetryInterval = defaultConnectRetryInterval
+	}
+	if c.ConnectRetryMaxInterval == 0 {
+		c.ConnectRetryMaxInterval = defaultConnectRetryMaxInterval
+	}
+}
+
+// mergeParams 以defaults为基础合并Params，按key排序保证dsn稳定
+func (c *Config) mergeParams(defaults map[string]string) ([]string, map[string]string) {
+	params := make(map[string]string, len(defaults)+len(c.Params))
+	for k, v := range defaults {
+		params[k] = v
+	}
+	for k, v := range c.Params {
+		params[k] = v
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, params
 }
 
 func (c *Config) formMysqlDSN() string {
-	format := "%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local"
-	return fmt.Sprintf(format, c.User, c.Password, c.Host, c.Port, c.Database)
+	keys, params := c.mergeParams(defaultMysqlParams)
+	query := make([]string, len(keys))
+	for i, k := range keys {
+		query[i] = k + "=" + url.QueryEscape(params[k])
+	}
+	format := "%s:%s@tcp(%s:%d)/%s?%s"
+	return fmt.Sprintf(format, c.User, c.Password, c.Host, c.Port, c.Database, strings.Join(query, "&"))
 }
 
 func (c *Config) formPgDSN() string {
-	format := "host=%s port=%d user=%s password=%s dbname=%s sslmode=disable"
-	return fmt.Sprintf(format, c.Host, c.Port, c.User, c.Password, c.Database)
+	format := "host=%s port=%d user=%s password=%s dbname=%s"
+	dsn := fmt.Sprintf(format, c.Host, c.Port, c.User, quotePgValue(c.Password), c.Database)
+	keys, params := c.mergeParams(defaultPgParams)
+	for _, k := range keys {
+		dsn += " " + k + "=" + quotePgValue(params[k])
+	}
+	return dsn
+}
+
+// quotePgValue 按libpq规则对包含空格、引号的值加引号
+func quotePgValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v)
+	return "'" + v + "'"
+}
+
+// 内存模式使用shared cache，同一进程内相同Database的连接共享一个库，
+// 最后一个连接关闭后数据即被销毁
+func (c *Config) formSqliteDSN() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	name := c.Database
+	if name == "" {
+		name = defaultSqliteMemoryName
+	}
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
 }
 
 func (c *Config) setConnPoolParams(db *gorm.DB) {
@@ -58,28 +175,175 @@ func (c *Config) setConnPoolParams(db *gorm.DB) {
 	db.DB().SetConnMaxLifetime(c.ConnMaxLifeTime)
 }
 
+// open 连接数据库，source为dsn或*sql.DB，失败时按指数退避重试ConnectRetries次，
+//	ctx结束时停止重试并返回最后一次的连接错误
+func (c *Config) open(ctx context.Context, dialect string, source interface{}) (*gorm.DB, error) {
+	interval := c.ConnectRetryInterval
+	for attempt := 0; ; attempt++ {
+		db, err := gorm.Open(dialect, source)
+		if err == nil {
+			db.SingularTable(true)
+			c.setConnPoolParams(db)
+			return db, nil
+		}
+		if attempt >= c.ConnectRetries {
+			return nil, err
+		}
+		if c.Logger != nil {
+			c.Logger.Printf("connect %s failed, retry in %s: %s", dialect, interval, err)
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("connect %s aborted: %w, last error: %s", dialect, ctx.Err(), err)
+		case <-timer.C:
+		}
+		interval *= 2
+		if interval > c.ConnectRetryMaxInterval {
+			interval = c.ConnectRetryMaxInterval
+		}
+	}
+}
+
 // connect mysql db server
 func (c *Config) OpenMysql() (*gorm.DB, error) {
+	return c.OpenMysqlContext(context.Background())
+}
+
+// connect mysql db server, connect retries stop when ctx is done
+func (c *Config) OpenMysqlContext(ctx context.Context) (*gorm.DB, error) {
 	c.check()
-	dsn := c.formMysqlDSN()
-	db, err := gorm.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-	db.SingularTable(true)
-	c.setConnPoolParams(db)
-	return db, nil
+	return c.open(ctx, "mysql", c.formMysqlDSN())
 }
 
 // connect pg db server
 func (c *Config) OpenPostgre() (*gorm.DB, error) {
+	return c.OpenPostgreContext(context.Background())
+}
+
+// connect pg db server, connect retries stop when ctx is done
+func (c *Config) OpenPostgreContext(ctx context.Context) (*gorm.DB, error) {
 	c.check()
-	dsn := c.formPgDSN()
-	db, err := gorm.Open("postgres", dsn)
+	return c.open(ctx, "postgres", c.formPgDSN())
+}
+
+// replica 返回以从库地址替换主库地址的配置，其余参数与主库一致
+func (c *Config) replica(r ReplicaConfig) *Config {
+	rc := *c
+	rc.Host = r.Host
+	rc.Port = r.Port
+	rc.Replicas = nil
+	return &rc
+}
+
+// connect all mysql replica servers, in the order of Replicas
+func (c *Config) OpenMysqlReplicas() ([]*gorm.DB, error) {
+	return c.OpenMysqlReplicasContext(context.Background())
+}
+
+// connect all mysql replica servers, connect retries stop when ctx is done
+func (c *Config) OpenMysqlReplicasContext(ctx context.Context) ([]*gorm.DB, error) {
+	return c.openReplicas(ctx, (*Config).OpenMysqlContext)
+}
+
+// connect all pg replica servers, in the order of Replicas
+func (c *Config) OpenPostgreReplicas() ([]*gorm.DB, error) {
+	return c.OpenPostgreReplicasContext(context.Background())
+}
+
+// connect all pg replica servers, connect retries stop when ctx is done
+func (c *Config) OpenPostgreReplicasContext(ctx context.Context) ([]*gorm.DB, error) {
+	return c.openReplicas(ctx, (*Config).OpenPostgreContext)
+}
+
+func (c *Config) openReplicas(ctx context.Context, open func(*Config, context.Context) (*gorm.DB, error)) ([]*gorm.DB, error) {
+	dbs := make([]*gorm.DB, 0, len(c.Replicas))
+	for _, r := range c.Replicas {
+		db, err := open(c.replica(r), ctx)
+		if err != nil {
+			for _, opened := range dbs {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("connect replica %s:%d failed: %w", r.Host, r.Port, err)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// connect sqlite db, in-memory if Path is empty
+//	内存模式额外持有一个不占连接池的保活连接，避免池中连接全部关闭后库被销毁，
+//	db关闭时保活连接随之释放
+func (c *Config) OpenSqlite() (*gorm.DB, error) {
+	return c.OpenSqliteContext(context.Background())
+}
+
+// connect sqlite db, connect retries stop when ctx is done
+func (c *Config) OpenSqliteContext(ctx context.Context) (*gorm.DB, error) {
+	c.check()
+	if c.Path != "" {
+		return c.open(ctx, "sqlite3", c.formSqliteDSN())
+	}
+	connector, err := newSqliteMemoryConnector(c.formSqliteDSN())
+	if err != nil {
+		return nil, err
+	}
+	sqlDB := sql.OpenDB(connector)
+	db, err := c.open(ctx, "sqlite3", sqlDB)
 	if err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
-	db.SingularTable(true)
-	c.setConnPoolParams(db)
 	return db, nil
-}
\ No newline at end of file
+}
+
+// sqliteMemoryConnector 内存库连接器，sql.DB关闭时会调用Close释放保活连接(需要Go 1.17)
+type sqliteMemoryConnector struct {
+	dsn       string
+	driver    *sqlite3.SQLiteDriver
+	keepAlive driver.Conn
+	closeOnce sync.Once
+}
+
+func newSqliteMemoryConnector(dsn string) (*sqliteMemoryConnector, error) {
+	d := &sqlite3.SQLiteDriver{}
+	conn, err := d.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteMemoryConnector{dsn: dsn, driver: d, keepAlive: conn}, nil
+}
+
+func (s *sqliteMemoryConnector) Connect(context.Context) (driver.Conn, error) {
+	return s.driver.Open(s.dsn)
+}
+
+func (s *sqliteMemoryConnector) Driver() driver.Driver {
+	return s.driver
+}
+
+func (s *sqliteMemoryConnector) Close() (err error) {
+	s.closeOnce.Do(func() { err = s.keepAlive.Close() })
+	return err
+}
+
+// Open 根据Dialect连接数据库
+func (c *Config) Open() (*gorm.DB, error) {
+	return c.OpenContext(context.Background())
+}
+
+// OpenContext 根据Dialect连接数据库，ctx结束时停止连接重试
+func (c *Config) OpenContext(ctx context.Context) (*gorm.DB, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Dialect {
+	case DialectMysql:
+		return c.OpenMysqlContext(ctx)
+	case DialectPostgres:
+		return c.OpenPostgreContext(ctx)
+	default:
+		return c.OpenSqliteContext(ctx)
+	}
+}
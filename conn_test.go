@@ -1,9 +1,26 @@
 package orm
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
+// tempDir 创建测试用临时目录，测试结束后删除
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "orm")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
 func TestConfig_OpenMysql(t *testing.T) {
 	c := &Config{
 		Host: "localhost",
@@ -16,4 +33,103 @@ func TestConfig_OpenMysql(t *testing.T) {
 	if err != nil {
 		t.Logf("connect mysql server failed: %s", err.Error())
 	}
-}
\ No newline at end of file
+}
+
+func TestConfig_OpenSqlite(t *testing.T) {
+	c := &Config{Database: "conn_test"}
+	db, err := c.OpenSqlite()
+	if err != nil {
+		t.Fatalf("open sqlite memory db failed: %s", err)
+	}
+	defer db.Close()
+	if err = db.DB().Ping(); err != nil {
+		t.Fatalf("ping sqlite memory db failed: %s", err)
+	}
+	if c.MaxOpenConns != defaultMaxOpenConns {
+		t.Errorf("pool params not applied, max open conns: %d", c.MaxOpenConns)
+	}
+
+	c = &Config{Path: filepath.Join(tempDir(t), "orm.db")}
+	db, err = c.OpenSqlite()
+	if err != nil {
+		t.Fatalf("open sqlite file db failed: %s", err)
+	}
+	defer db.Close()
+	if err = db.DB().Ping(); err != nil {
+		t.Fatalf("ping sqlite file db failed: %s", err)
+	}
+}
+
+func TestConfig_OpenSqlite_KeepAlive(t *testing.T) {
+	c := &Config{Database: "conn_keep_alive", MaxOpenConns: 1}
+	db, err := c.OpenSqlite()
+	if err != nil {
+		t.Fatalf("open sqlite memory db failed: %s", err)
+	}
+	if err = db.Exec("create table keep_alive (id integer)").Error; err != nil {
+		t.Fatal(err)
+	}
+	// 池中空闲连接全部关闭后库依然存在，且保活连接不占用MaxOpenConns
+	db.DB().SetMaxIdleConns(0)
+	if err = db.Exec("insert into keep_alive values (1)").Error; err != nil {
+		t.Fatalf("memory db should survive pool conns closed: %s", err)
+	}
+	db.Close()
+
+	// db关闭后保活连接被释放，同名库重新打开为空库
+	db, err = c.OpenSqlite()
+	if err != nil {
+		t.Fatalf("reopen sqlite memory db failed: %s", err)
+	}
+	defer db.Close()
+	if db.HasTable("keep_alive") {
+		t.Error("memory db should be destroyed after db closed")
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestConfig_OpenRetry(t *testing.T) {
+	logger := &testLogger{}
+	c := &Config{
+		Path:                    filepath.Join(tempDir(t), "not_exist", "orm.db"),
+		ConnectRetries:          2,
+		ConnectRetryInterval:    time.Millisecond,
+		ConnectRetryMaxInterval: time.Millisecond,
+		Logger:                  logger,
+	}
+	start := time.Now()
+	if _, err := c.OpenSqlite(); err == nil {
+		t.Fatal("open db in not exist dir should fail")
+	}
+	if cost := time.Since(start); cost < 2*time.Millisecond {
+		t.Errorf("should retry with backoff, cost %s", cost)
+	}
+	if len(logger.lines) != 2 {
+		t.Errorf("should log each retry, got %v", logger.lines)
+	}
+}
+
+func TestConfig_OpenRetry_Canceled(t *testing.T) {
+	c := &Config{
+		Path:                 filepath.Join(tempDir(t), "not_exist", "orm.db"),
+		ConnectRetries:       100,
+		ConnectRetryInterval: time.Hour,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.OpenSqliteContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("retry should stop when ctx done, got %v", err)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Errorf("retry should abort in time, cost %s", cost)
+	}
+}